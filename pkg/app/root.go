@@ -3,6 +3,7 @@ package app
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -18,9 +19,10 @@ import (
 )
 
 var (
-	RootCmd   *cobra.Command
-	appConfig *Config
-	modules   []module.Module
+	RootCmd     *cobra.Command
+	appConfig   *Config
+	modules     []module.Module
+	moduleTasks map[module.Module][]*module.Task
 )
 
 type Config struct {
@@ -49,13 +51,32 @@ func RegisterModule(p module.Module) {
 		log.Info("Module supports periodic tasks", "module", fmt.Sprintf("%T", p), "count", len(periodicTasks))
 		for i := range withPeriodicTasks.PeriodicTasks() {
 			taskConfig := periodicTasks[i]
-			log.Info("Registering task for module", "module", fmt.Sprintf("%T", p), "task", taskConfig.Name, "interval", taskConfig.Interval)
-			task := module.NewTask(taskConfig.Name, taskConfig.Task, taskConfig.Interval)
+			log.Info("Registering task for module", "module", fmt.Sprintf("%T", p), "task", taskConfig.Name, "interval", taskConfig.Interval, "singleton", taskConfig.Locker != nil)
+			task := module.NewTaskFromConfig(taskConfig)
 			modules = append(modules, task)
+
+			if moduleTasks == nil {
+				moduleTasks = make(map[module.Module][]*module.Task)
+			}
+			moduleTasks[p] = append(moduleTasks[p], task)
 		}
 	}
 }
 
+func Tasks() []*module.Task {
+	tasks := make([]*module.Task, 0)
+	for i := range modules {
+		tasks = append(tasks, moduleTasks[modules[i]]...)
+	}
+	return tasks
+}
+
+// TaskAdminHandler returns the periodic task admin handler for mounting under
+// prefix in an HTTP-based module, e.g. PprofModule.Handle.
+func TaskAdminHandler(prefix string) http.Handler {
+	return http.StripPrefix(prefix, module.NewTaskAdminHandler(Tasks))
+}
+
 func doRun(cmd *cobra.Command, args []string) {
 	var config string
 	var err error
@@ -87,7 +108,11 @@ func doRun(cmd *cobra.Command, args []string) {
 		module.MustConfigure(modules[i])
 	}
 
-	// Run init + first iteration of periodic tasks if any
+	// Run init + first iteration of periodic tasks if any. Singleton tasks
+	// need their locker's module (e.g. EtcdClientModule), which may be
+	// registered after the task's own module, so their first iteration waits
+	// until every module is initialized.
+	var singletonTasks []*module.Task
 	for i := range modules {
 		if modules[i].HasInit() {
 			err := modules[i].Init(ctx)
@@ -97,16 +122,22 @@ func doRun(cmd *cobra.Command, args []string) {
 			}
 		}
 
-		withPeriodicTasks, ok := modules[i].(module.WithPeriodicTasks)
-		if ok {
-			periodicTasks := withPeriodicTasks.PeriodicTasks()
-			for j := range periodicTasks {
-				log.Info("Running initial iteration of periodic task for module", "name", modules[i].GetName(), "task", periodicTasks[j].Name)
-				periodicTasks[j].Task()
+		periodicTasks := moduleTasks[modules[i]]
+		for j := range periodicTasks {
+			if periodicTasks[j].IsSingleton() {
+				singletonTasks = append(singletonTasks, periodicTasks[j])
+				continue
 			}
+			log.Info("Running initial iteration of periodic task for module", "name", modules[i].GetName(), "task", periodicTasks[j].GetName())
+			periodicTasks[j].Run(ctx)
 		}
 	}
 
+	for i := range singletonTasks {
+		log.Info("Running initial iteration of singleton periodic task", "task", singletonTasks[i].GetName())
+		singletonTasks[i].Run(ctx)
+	}
+
 	for i := range modules {
 		if modules[i].HasMain() {
 			f := func() error {
@@ -0,0 +1,102 @@
+package module
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+type TaskProvider = func() []*Task
+
+type taskAdminHandler struct {
+	tasks TaskProvider
+}
+
+// NewTaskAdminHandler returns an HTTP handler that lists periodic tasks and
+// lets operators trigger, pause and resume them. It expects request paths
+// relative to its mount point:
+//
+//	GET  /                  list all tasks
+//	POST /<task>/trigger    run the task now
+//	POST /<task>/pause      skip scheduled runs until resumed
+//	POST /<task>/resume     resume scheduled runs
+func NewTaskAdminHandler(tasks TaskProvider) http.Handler {
+	return &taskAdminHandler{tasks: tasks}
+}
+
+func (h *taskAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+
+	if path == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.list(w)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taskName, action := parts[0], parts[1]
+	task := h.find(taskName)
+	if task == nil {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+
+	switch action {
+	case "trigger":
+		if !task.Trigger() {
+			http.Error(w, "task run already pending", http.StatusConflict)
+			return
+		}
+	case "pause":
+		task.Pause()
+	case "resume":
+		task.Resume()
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	log.Info("Periodic task admin action", "task", taskName, "action", action, "remote_addr", r.RemoteAddr, "user_agent", r.UserAgent())
+	writeJSON(w, task.Status())
+}
+
+func (h *taskAdminHandler) list(w http.ResponseWriter) {
+	tasks := h.tasks()
+	statuses := make([]TaskStatus, 0, len(tasks))
+	for _, task := range tasks {
+		statuses = append(statuses, task.Status())
+	}
+	writeJSON(w, statuses)
+}
+
+func (h *taskAdminHandler) find(name string) *Task {
+	for _, task := range h.tasks() {
+		if task.GetName() == name {
+			return task
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Error("Failed to encode admin response", "error", err)
+	}
+}
@@ -3,6 +3,8 @@ package module
 import (
 	"context"
 	"fmt"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/charmbracelet/log"
@@ -16,10 +18,27 @@ type WithPeriodicTasks interface {
 	PeriodicTasks() []*TaskConfig
 }
 
+// TaskLocker guards a single scheduled run of a cluster-singleton task.
+// TryLock reports whether the caller should execute the run; when it does,
+// the returned unlock function must be called once the run is finished.
+type TaskLocker interface {
+	TryLock(ctx context.Context, task string, interval time.Duration) (unlock func(), acquired bool, err error)
+}
+
 type TaskConfig struct {
 	Name     string
 	Task     TaskFunc
 	Interval time.Duration
+
+	// TaskWithError is used instead of Task when set. Returned errors and
+	// panics in either function are logged and, if Retry is configured,
+	// retried within the same run.
+	TaskWithError TaskWithErrorFunc
+	Retry         *RetryConfig
+
+	// Locker makes the task cluster-singleton: each scheduled run is executed
+	// only by the replica that acquires the lock.
+	Locker TaskLocker
 }
 
 type Module interface {
@@ -76,17 +95,38 @@ func (m Base) Cleanup(_ context.Context) {
 
 type TaskFunc = func()
 
+type TaskWithErrorFunc = func(ctx context.Context) error
+
 type Task struct {
 	Base
-	interval time.Duration
-	task     TaskFunc
+	config  *TaskConfig
+	trigger chan struct{}
+
+	mu        sync.Mutex
+	paused    bool
+	running   bool
+	lastRun   time.Time
+	nextRun   time.Time
+	lastError error
+}
+
+type TaskStatus struct {
+	Name      string     `json:"name"`
+	Interval  string     `json:"interval"`
+	Singleton bool       `json:"singleton"`
+	Paused    bool       `json:"paused"`
+	Running   bool       `json:"running"`
+	LastRun   *time.Time `json:"lastRun,omitempty"`
+	NextRun   *time.Time `json:"nextRun,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
 }
 
 func (p *Task) Main(ctx context.Context) error {
 	log.Info("Starting periodic task", "name", p.GetName())
 
-	ticker := time.NewTicker(p.interval)
+	ticker := time.NewTicker(p.config.Interval)
 	defer ticker.Stop()
+	p.setNextRun(time.Now().Add(p.config.Interval))
 
 mainLoop:
 	for {
@@ -94,7 +134,15 @@ mainLoop:
 		case <-ctx.Done():
 			break mainLoop
 		case <-ticker.C:
-			p.task()
+			p.setNextRun(time.Now().Add(p.config.Interval))
+			if p.IsPaused() {
+				log.Debug("Skipping paused periodic task", "name", p.GetName())
+				continue
+			}
+			p.Run(ctx)
+		case <-p.trigger:
+			log.Info("Running triggered periodic task", "name", p.GetName())
+			p.Run(ctx)
 		}
 	}
 
@@ -102,6 +150,115 @@ mainLoop:
 	return nil
 }
 
+func (p *Task) Run(ctx context.Context) {
+	if p.config.Locker != nil {
+		unlock, acquired, err := p.config.Locker.TryLock(ctx, p.GetName(), p.config.Interval)
+		if err != nil {
+			log.Error("Failed to acquire lock for periodic task", "name", p.GetName(), "error", err)
+			return
+		}
+		if !acquired {
+			log.Debug("Skipping periodic task run, lock is held elsewhere", "name", p.GetName())
+			return
+		}
+		defer unlock()
+	}
+
+	p.mu.Lock()
+	p.running = true
+	p.mu.Unlock()
+
+	err := p.runWithRetry(ctx)
+	if err != nil {
+		log.Error("Periodic task failed", "name", p.GetName(), "error", err)
+	}
+
+	p.mu.Lock()
+	p.running = false
+	p.lastRun = time.Now()
+	p.lastError = err
+	p.mu.Unlock()
+}
+
+// Trigger schedules an immediate run in the task's Main loop. It returns
+// false if a triggered run is already pending.
+func (p *Task) Trigger() bool {
+	select {
+	case p.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Task) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = true
+}
+
+func (p *Task) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = false
+}
+
+// IsSingleton reports whether runs are guarded by a cluster-wide lock.
+func (p *Task) IsSingleton() bool {
+	return p.config.Locker != nil
+}
+
+func (p *Task) IsPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+func (p *Task) Status() TaskStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := TaskStatus{
+		Name:      p.GetName(),
+		Interval:  p.config.Interval.String(),
+		Singleton: p.IsSingleton(),
+		Paused:    p.paused,
+		Running:   p.running,
+	}
+
+	if !p.lastRun.IsZero() {
+		lastRun := p.lastRun
+		status.LastRun = &lastRun
+	}
+
+	if !p.nextRun.IsZero() && !p.paused {
+		nextRun := p.nextRun
+		status.NextRun = &nextRun
+	}
+
+	if p.lastError != nil {
+		status.LastError = p.lastError.Error()
+	}
+
+	return status
+}
+
+func (p *Task) setNextRun(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextRun = t
+}
+
+// InstanceIdentity identifies this process among replicas, e.g. as a lock or
+// lease holder.
+func InstanceIdentity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
 func MustConfigure(mod Module) {
 	err := mod.Configure()
 	if err != nil {
@@ -110,5 +267,9 @@ func MustConfigure(mod Module) {
 }
 
 func NewTask(name string, task TaskFunc, interval time.Duration) *Task {
-	return &Task{Base: Base{Name: name, IncludesMain: true}, task: task, interval: interval}
+	return NewTaskFromConfig(&TaskConfig{Name: name, Task: task, Interval: interval})
+}
+
+func NewTaskFromConfig(config *TaskConfig) *Task {
+	return &Task{Base: Base{Name: config.Name, IncludesMain: true}, config: config, trigger: make(chan struct{}, 1)}
 }
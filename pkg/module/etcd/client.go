@@ -14,14 +14,21 @@ import (
 
 type EtcdClientModule struct {
 	module.Base
-	client *client.Client
-	cfg    client.Config
+	client     *client.Client
+	cfg        client.Config
+	lockConfig LockConfig
+	locker     *locker
+
+	LockMetrics *LockMetrics
 }
 
 func (p *EtcdClientModule) Configure() error {
 	configPrefix := fmt.Sprintf("etcd-%s", p.GetName())
 	endpoints := viper.GetStringSlice(fmt.Sprintf("%s.endpoints", configPrefix))
 	maxCallSendMsgSize := viper.GetInt(fmt.Sprintf("%s.max_call_send_msg_size", configPrefix))
+	lockPrefix := viper.GetString(fmt.Sprintf("%s.lock_prefix", configPrefix))
+	lockIdentity := viper.GetString(fmt.Sprintf("%s.lock_identity", configPrefix))
+	lockTTL := viper.GetInt(fmt.Sprintf("%s.lock_ttl", configPrefix))
 
 	if len(endpoints) == 0 {
 		return fmt.Errorf("Invalid configuration: missing `endpoints` configuration")
@@ -35,12 +42,34 @@ func (p *EtcdClientModule) Configure() error {
 		return fmt.Errorf("Invalid configuration: %s.max_call_send_msg_size can't be less than 0", configPrefix)
 	}
 
+	if lockPrefix == "" {
+		lockPrefix = fmt.Sprintf("/microboiler/%s", p.GetName())
+	}
+
+	if lockIdentity == "" {
+		lockIdentity = module.InstanceIdentity()
+	}
+
+	if lockTTL == 0 {
+		lockTTL = 10
+	} else if lockTTL < 0 {
+		return fmt.Errorf("Invalid configuration: %s.lock_ttl can't be less than 0", configPrefix)
+	}
+
+	p.lockConfig = LockConfig{
+		Prefix:   lockPrefix,
+		Identity: lockIdentity,
+		TTL:      lockTTL,
+	}
+
 	p.cfg = client.Config{
 		Endpoints:          endpoints,
 		DialTimeout:        2 * time.Second,
 		MaxCallSendMsgSize: maxCallSendMsgSize,
 	}
 
+	module.RegisterCollector(fmt.Sprintf("%s-locks", configPrefix), p.LockMetrics)
+
 	return nil
 }
 
@@ -56,15 +85,22 @@ func (p *EtcdClientModule) Init(_ context.Context) error {
 }
 
 func (p *EtcdClientModule) Cleanup(_ context.Context) {
+	p.locker.close()
 	if p.client != nil {
 		p.client.Close()
 	}
 }
 
 func NewEtcdClientModule(name string) *EtcdClientModule {
-	return &EtcdClientModule{Base: module.Base{Name: name, IncludesInit: true}}
+	p := &EtcdClientModule{Base: module.Base{Name: name, IncludesInit: true, IncludesCleanup: true}, LockMetrics: newLockMetrics(name)}
+	p.locker = &locker{module: p}
+	return p
 }
 
 func (p *EtcdClientModule) GetClient() *client.Client {
 	return p.client
 }
+
+func (p *EtcdClientModule) TaskLocker() module.TaskLocker {
+	return p.locker
+}
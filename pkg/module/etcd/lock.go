@@ -0,0 +1,204 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/prometheus/client_golang/prometheus"
+	client "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+const (
+	lockResultAcquired  = "acquired"
+	lockResultContended = "contended"
+	lockResultSkipped   = "skipped"
+	lockResultError     = "error"
+)
+
+type LockConfig struct {
+	Prefix   string
+	Identity string
+	TTL      int
+}
+
+type LockMetrics struct {
+	attempts *prometheus.CounterVec
+	holder   *prometheus.GaugeVec
+}
+
+func (m *LockMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.attempts.Describe(ch)
+	m.holder.Describe(ch)
+}
+
+func (m *LockMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.attempts.Collect(ch)
+	m.holder.Collect(ch)
+}
+
+func (m *LockMetrics) observeHolder(task string, holder string) {
+	m.holder.DeletePartialMatch(prometheus.Labels{"task": task})
+	if holder != "" {
+		m.holder.WithLabelValues(task, holder).Set(1)
+	}
+}
+
+func newLockMetrics(name string) *LockMetrics {
+	constLabels := prometheus.Labels{"app": name}
+	return &LockMetrics{
+		attempts: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "etcd_task_lock_attempts_total",
+				Help:        "Total number of attempts to acquire a periodic task lock, by result.",
+				ConstLabels: constLabels,
+			},
+			[]string{"task", "result"},
+		),
+		holder: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:        "etcd_task_lock_holder",
+				Help:        "Last observed holder of a periodic task lock.",
+				ConstLabels: constLabels,
+			},
+			[]string{"task", "holder"},
+		),
+	}
+}
+
+// locker implements module.TaskLocker on top of an etcd lease-based mutex.
+// Besides the mutex itself it records the holder identity and the schedule
+// slot (the start of the interval, counted from the Unix epoch) of the last
+// run, so that each slot runs at most once however the replicas' tickers are
+// offset from each other.
+type locker struct {
+	module  *EtcdClientModule
+	mu      sync.Mutex
+	session *concurrency.Session
+}
+
+func (l *locker) getSession() (*concurrency.Session, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.session != nil {
+		select {
+		case <-l.session.Done():
+			log.Warn("Etcd lock session expired, creating a new one", "name", l.module.GetName())
+		default:
+			return l.session, nil
+		}
+	}
+
+	if l.module.client == nil {
+		return nil, fmt.Errorf("etcd client %s is not initialized", l.module.GetName())
+	}
+
+	session, err := concurrency.NewSession(l.module.client, concurrency.WithTTL(l.module.lockConfig.TTL))
+	if err != nil {
+		return nil, err
+	}
+	l.session = session
+
+	return session, nil
+}
+
+func (l *locker) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.session != nil {
+		l.session.Close()
+		l.session = nil
+	}
+}
+
+func (l *locker) keys(task string) (string, string, string) {
+	prefix := l.module.lockConfig.Prefix
+	return path.Join(prefix, "locks", task), path.Join(prefix, "holders", task), path.Join(prefix, "runs", task)
+}
+
+func (l *locker) currentHolder(ctx context.Context, holderKey string) string {
+	resp, err := l.module.client.Get(ctx, holderKey)
+	if err != nil || len(resp.Kvs) == 0 {
+		return ""
+	}
+	return string(resp.Kvs[0].Value)
+}
+
+func (l *locker) TryLock(ctx context.Context, task string, interval time.Duration) (func(), bool, error) {
+	metrics := l.module.LockMetrics
+	identity := l.module.lockConfig.Identity
+	lockKey, holderKey, runKey := l.keys(task)
+
+	session, err := l.getSession()
+	if err != nil {
+		metrics.attempts.WithLabelValues(task, lockResultError).Inc()
+		return nil, false, err
+	}
+
+	mutex := concurrency.NewMutex(session, lockKey)
+	err = mutex.TryLock(ctx)
+	if errors.Is(err, concurrency.ErrLocked) {
+		holder := l.currentHolder(ctx, holderKey)
+		log.Info("Periodic task lock is held by another replica", "name", l.module.GetName(), "task", task, "holder", holder)
+		metrics.attempts.WithLabelValues(task, lockResultContended).Inc()
+		metrics.observeHolder(task, holder)
+		return nil, false, nil
+	}
+	if err != nil {
+		log.Error("Failed to acquire periodic task lock", "name", l.module.GetName(), "task", task, "error", err)
+		metrics.attempts.WithLabelValues(task, lockResultError).Inc()
+		return nil, false, err
+	}
+
+	slot := time.Now().Truncate(interval)
+	resp, err := l.module.client.Get(ctx, runKey)
+	if err != nil {
+		mutex.Unlock(context.Background())
+		metrics.attempts.WithLabelValues(task, lockResultError).Inc()
+		return nil, false, err
+	}
+	if len(resp.Kvs) > 0 {
+		lastSlot, parseErr := time.Parse(time.RFC3339Nano, string(resp.Kvs[0].Value))
+		if parseErr == nil && !lastSlot.Before(slot) {
+			mutex.Unlock(context.Background())
+			log.Debug("Periodic task already ran for this interval", "name", l.module.GetName(), "task", task, "slot", slot)
+			metrics.attempts.WithLabelValues(task, lockResultSkipped).Inc()
+			return nil, false, nil
+		}
+	}
+
+	_, err = l.module.client.Put(ctx, holderKey, identity, client.WithLease(session.Lease()))
+	if err != nil {
+		log.Warn("Failed to record periodic task lock holder", "name", l.module.GetName(), "task", task, "error", err)
+	}
+
+	log.Info("Acquired periodic task lock", "name", l.module.GetName(), "task", task, "holder", identity)
+	metrics.attempts.WithLabelValues(task, lockResultAcquired).Inc()
+	metrics.observeHolder(task, identity)
+
+	unlock := func() {
+		unlockCtx, cancel := context.WithTimeout(context.Background(), l.module.cfg.DialTimeout)
+		defer cancel()
+
+		_, err := l.module.client.Put(unlockCtx, runKey, slot.UTC().Format(time.RFC3339Nano))
+		if err != nil {
+			log.Warn("Failed to record periodic task run", "name", l.module.GetName(), "task", task, "error", err)
+		}
+		l.module.client.Delete(unlockCtx, holderKey)
+
+		err = mutex.Unlock(unlockCtx)
+		if err != nil {
+			log.Error("Failed to release periodic task lock", "name", l.module.GetName(), "task", task, "error", err)
+		}
+		metrics.observeHolder(task, "")
+	}
+
+	return unlock, true, nil
+}
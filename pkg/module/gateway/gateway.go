@@ -0,0 +1,309 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	"github.com/dnikishov/microboiler/pkg/module"
+	grpcmod "github.com/dnikishov/microboiler/pkg/module/grpc"
+	"github.com/dnikishov/microboiler/pkg/module/listener"
+)
+
+const metadataHeaderPrefix = "Grpc-Metadata-"
+
+var defaultForwardHeaders = []string{"Authorization", "X-Request-Id", "X-Api-Key"}
+
+type Config struct {
+	ListenAddress  string
+	PathPrefix     string
+	MaxBodySize    int64
+	ForwardHeaders []string
+	SharedListener string
+}
+
+type method struct {
+	fullMethod string
+	input      protoreflect.MessageType
+	output     protoreflect.MessageType
+}
+
+type GRPCGatewayModule struct {
+	module.Base
+	grpcServer *grpcmod.GRPCServerModule
+	config     *Config
+
+	conn     *grpc.ClientConn
+	methods  map[string]*method
+	serveMux *http.ServeMux
+	server   *http.Server
+}
+
+func (p *GRPCGatewayModule) Configure() error {
+	configPrefix := fmt.Sprintf("grpc-gateway-%s", p.GetName())
+
+	listenAddress := viper.GetString(fmt.Sprintf("%s.listen_address", configPrefix))
+	pathPrefix := viper.GetString(fmt.Sprintf("%s.path_prefix", configPrefix))
+	maxBodySize := viper.GetSizeInBytes(fmt.Sprintf("%s.max_body_size", configPrefix))
+	forwardHeaders := viper.GetStringSlice(fmt.Sprintf("%s.forward_headers", configPrefix))
+	sharedListener := viper.GetString(fmt.Sprintf("%s.shared_listener", configPrefix))
+
+	if listenAddress == "" && sharedListener == "" {
+		return fmt.Errorf("Invalid configuration: %s.listen_address is not set", configPrefix)
+	}
+
+	pathPrefix = "/" + strings.Trim(pathPrefix, "/")
+	if pathPrefix != "/" {
+		pathPrefix += "/"
+	}
+
+	if maxBodySize == 0 {
+		maxBodySize = 4 * 1024 * 1024
+	}
+
+	p.config = &Config{
+		ListenAddress:  listenAddress,
+		PathPrefix:     pathPrefix,
+		MaxBodySize:    int64(maxBodySize),
+		ForwardHeaders: append(defaultForwardHeaders, forwardHeaders...),
+		SharedListener: sharedListener,
+	}
+
+	p.grpcServer.EnableInProcess()
+
+	return nil
+}
+
+func (p *GRPCGatewayModule) Init(ctx context.Context) error {
+	var err error
+	p.conn, err = p.grpcServer.DialInProcess(ctx)
+	if err != nil {
+		return err
+	}
+
+	p.methods = make(map[string]*method)
+	for _, entry := range p.grpcServer.Services() {
+		p.registerService(entry.ServiceDesc.ServiceName)
+	}
+
+	p.serveMux = http.NewServeMux()
+	p.serveMux.Handle(p.config.PathPrefix, http.StripPrefix(strings.TrimSuffix(p.config.PathPrefix, "/"), p))
+
+	if p.config.SharedListener != "" {
+		shared, err := listener.Lookup(p.config.SharedListener)
+		if err != nil {
+			return err
+		}
+		err = shared.Handle(p.config.PathPrefix, p.serveMux)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info("GRPC gateway initialized", "name", p.GetName(), "methods", len(p.methods), "prefix", p.config.PathPrefix)
+
+	return nil
+}
+
+func (p *GRPCGatewayModule) registerService(serviceName string) {
+	descriptor, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(serviceName))
+	if err != nil {
+		log.Warn("GRPC service has no registered descriptor, skipping in gateway", "name", p.GetName(), "service", serviceName, "error", err)
+		return
+	}
+
+	serviceDescriptor, ok := descriptor.(protoreflect.ServiceDescriptor)
+	if !ok {
+		log.Warn("Descriptor is not a service, skipping in gateway", "name", p.GetName(), "service", serviceName)
+		return
+	}
+
+	methods := serviceDescriptor.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		fullMethod := fmt.Sprintf("/%s/%s", serviceName, md.Name())
+
+		if md.IsStreamingClient() || md.IsStreamingServer() {
+			log.Info("Streaming methods are not exposed in gateway", "name", p.GetName(), "method", fullMethod)
+			continue
+		}
+
+		input, err := protoregistry.GlobalTypes.FindMessageByName(md.Input().FullName())
+		if err != nil {
+			log.Warn("Unknown input type, skipping method in gateway", "name", p.GetName(), "method", fullMethod, "error", err)
+			continue
+		}
+		output, err := protoregistry.GlobalTypes.FindMessageByName(md.Output().FullName())
+		if err != nil {
+			log.Warn("Unknown output type, skipping method in gateway", "name", p.GetName(), "method", fullMethod, "error", err)
+			continue
+		}
+
+		p.methods[fullMethod] = &method{fullMethod: fullMethod, input: input, output: output}
+	}
+}
+
+func (p *GRPCGatewayModule) Main(ctx context.Context) error {
+	if p.config.SharedListener != "" {
+		log.Info("GRPC gateway is served by shared listener", "name", p.GetName(), "listener", p.config.SharedListener)
+		<-ctx.Done()
+		return nil
+	}
+
+	log.Info("Starting GRPC gateway", "name", p.GetName(), "address", p.config.ListenAddress)
+	p.server = &http.Server{Addr: p.config.ListenAddress, Handler: p.serveMux}
+	err := p.server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	log.Info("GRPC gateway stopped", "name", p.GetName(), "address", p.config.ListenAddress)
+	return nil
+}
+
+func (p *GRPCGatewayModule) Cleanup(ctx context.Context) {
+	log.Info("Stopping GRPC gateway", "name", p.GetName())
+	if p.server != nil {
+		p.server.Shutdown(ctx)
+	}
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
+
+// ServeHTTP handles POST /<package.Service>/<Method> requests relative to the
+// configured path prefix.
+func (p *GRPCGatewayModule) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m, ok := p.methods[r.URL.Path]
+	if !ok {
+		writeError(w, status.New(codes.Unimplemented, fmt.Sprintf("unknown method %s", r.URL.Path)))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeHTTPError(w, http.StatusMethodNotAllowed, status.New(codes.Unimplemented, "only POST is supported"))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, p.config.MaxBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeHTTPError(w, http.StatusRequestEntityTooLarge, status.New(codes.ResourceExhausted, fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit)))
+			return
+		}
+		writeError(w, status.New(codes.InvalidArgument, fmt.Sprintf("failed to read request body: %s", err)))
+		return
+	}
+
+	req := m.input.New().Interface()
+	if len(body) > 0 {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, req)
+		if err != nil {
+			writeError(w, status.New(codes.InvalidArgument, fmt.Sprintf("invalid request body: %s", err)))
+			return
+		}
+	}
+
+	ctx := metadata.NewOutgoingContext(r.Context(), p.incomingMetadata(r))
+	resp := m.output.New().Interface()
+	var header, trailer metadata.MD
+
+	err = p.conn.Invoke(ctx, m.fullMethod, req, resp, grpc.Header(&header), grpc.Trailer(&trailer))
+	writeMetadata(w, header)
+	writeMetadata(w, trailer)
+	if err != nil {
+		writeError(w, status.Convert(err))
+		return
+	}
+
+	data, err := protojson.Marshal(resp)
+	if err != nil {
+		writeError(w, status.New(codes.Internal, fmt.Sprintf("failed to encode response: %s", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (p *GRPCGatewayModule) incomingMetadata(r *http.Request) metadata.MD {
+	md := metadata.MD{}
+
+	for _, name := range p.config.ForwardHeaders {
+		if values := r.Header.Values(name); len(values) > 0 {
+			md.Append(strings.ToLower(name), values...)
+		}
+	}
+
+	for name, values := range r.Header {
+		if strings.HasPrefix(name, metadataHeaderPrefix) {
+			md.Append(strings.ToLower(strings.TrimPrefix(name, metadataHeaderPrefix)), values...)
+		}
+	}
+
+	// The in-process server takes the caller's address and certificate
+	// identity from the forwarded headers for authentication and rate
+	// limiting. ForwardCaller replaces any the client sent itself, and the
+	// identity is only forwarded for a verified client certificate.
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		address = r.RemoteAddr
+	}
+	identity := ""
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		identity = grpcmod.CertificateIdentity(r.TLS.VerifiedChains[0][0])
+	}
+	p.grpcServer.ForwardCaller(md, address, identity)
+
+	return md
+}
+
+func writeMetadata(w http.ResponseWriter, md metadata.MD) {
+	for key, values := range md {
+		if strings.HasSuffix(key, "-bin") || key == "content-type" {
+			continue
+		}
+		headerName := textproto.CanonicalMIMEHeaderKey(key)
+		if key != grpcmod.RequestIDHeader {
+			headerName = metadataHeaderPrefix + headerName
+		}
+		for _, value := range values {
+			w.Header().Add(headerName, value)
+		}
+	}
+}
+
+func writeError(w http.ResponseWriter, st *status.Status) {
+	writeHTTPError(w, HTTPStatusFromCode(st.Code()), st)
+}
+
+func writeHTTPError(w http.ResponseWriter, httpStatus int, st *status.Status) {
+	data, err := protojson.Marshal(st.Proto())
+	if err != nil {
+		data = []byte(fmt.Sprintf(`{"code":%d,"message":%q}`, st.Code(), st.Message()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	w.Write(data)
+}
+
+func NewGRPCGatewayModule(name string, grpcServer *grpcmod.GRPCServerModule) *GRPCGatewayModule {
+	return &GRPCGatewayModule{Base: module.Base{Name: name, IncludesInit: true, IncludesMain: true, IncludesCleanup: true}, grpcServer: grpcServer}
+}
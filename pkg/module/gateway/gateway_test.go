@@ -0,0 +1,214 @@
+package gateway_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/dnikishov/microboiler/pkg/module/gateway"
+	grpcmod "github.com/dnikishov/microboiler/pkg/module/grpc"
+	"github.com/dnikishov/microboiler/pkg/module/grpc/grpctest"
+)
+
+// testService echoes requests, fails with the requested status, and reports
+// the caller and metadata it saw in response headers. UnaryCall blocks while
+// release is set, after signalling started.
+type testService struct {
+	testpb.UnimplementedTestServiceServer
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *testService) EmptyCall(ctx context.Context, _ *testpb.Empty) (*testpb.Empty, error) {
+	return &testpb.Empty{}, nil
+}
+
+func (s *testService) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	if s.release != nil {
+		s.started <- struct{}{}
+		<-s.release
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := metadata.MD{}
+	for _, key := range []string{"authorization", "x-tenant", grpcmod.ForwardedIdentityHeader} {
+		if values := md.Get(key); len(values) > 0 {
+			header.Set("seen-"+key, values...)
+		}
+	}
+	if pr, ok := peer.FromContext(ctx); ok {
+		header.Set("seen-peer", pr.Addr.String())
+	}
+	grpc.SetHeader(ctx, header)
+
+	if req.ResponseStatus != nil && req.ResponseStatus.Code != 0 {
+		return nil, status.Error(codes.Code(req.ResponseStatus.Code), req.ResponseStatus.Message)
+	}
+	return &testpb.SimpleResponse{Payload: req.Payload, Username: "tester"}, nil
+}
+
+func newGateway(t *testing.T, service *testService, config grpctest.Config) (*grpctest.Server, *gateway.GRPCGatewayModule) {
+	t.Helper()
+
+	server := grpctest.NewServer(t, &grpcmod.Options{ServiceRegistry: []grpcmod.RegistryEntry{
+		{ServiceDesc: testpb.TestService_ServiceDesc, Service: service},
+	}}, config)
+
+	gw := gateway.NewGRPCGatewayModule(server.Module.GetName(), server.Module)
+	key := fmt.Sprintf("grpc-gateway-%s.listen_address", gw.GetName())
+	viper.Set(key, "localhost:0")
+	t.Cleanup(func() { viper.Set(key, nil) })
+
+	if err := gw.Configure(); err != nil {
+		t.Fatalf("Configure() error = %s", err)
+	}
+	if err := gw.Init(context.Background()); err != nil {
+		t.Fatalf("Init() error = %s", err)
+	}
+	t.Cleanup(func() { gw.Cleanup(context.Background()) })
+
+	return server, gw
+}
+
+func post(gw http.Handler, method string, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/grpc.testing.TestService/"+method, strings.NewReader(body))
+	req.RemoteAddr = "192.0.2.10:40000"
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGatewayUnary(t *testing.T) {
+	_, gw := newGateway(t, &testService{}, grpctest.Config{"interceptors.requestId": true})
+
+	// Clients can't claim another address or a certificate identity by
+	// sending the forwarded headers themselves.
+	rec := post(gw, "UnaryCall", `{"payload":{"body":"aGVsbG8="},"unknownField":1}`, http.Header{
+		"Authorization":          {"Bearer token"},
+		"X-Request-Id":           {"req-1"},
+		"Grpc-Metadata-X-Tenant": {"acme"},
+		"Grpc-Metadata-" + http.CanonicalHeaderKey(grpcmod.ForwardedForHeader):      {"203.0.113.1"},
+		"Grpc-Metadata-" + http.CanonicalHeaderKey(grpcmod.ForwardedIdentityHeader): {"spiffe://cluster/admin"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	var resp struct {
+		Payload  struct{ Body string }
+		Username string
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %s: %s", rec.Body, err)
+	}
+	if resp.Payload.Body != "aGVsbG8=" || resp.Username != "tester" {
+		t.Errorf("response = %s", rec.Body)
+	}
+
+	wantHeaders := map[string]string{
+		"X-Request-Id":                     "req-1",
+		"Grpc-Metadata-Seen-Authorization": "Bearer token",
+		"Grpc-Metadata-Seen-X-Tenant":      "acme",
+		"Grpc-Metadata-Seen-Peer":          "192.0.2.10",
+		"Grpc-Metadata-Seen-" + http.CanonicalHeaderKey(grpcmod.ForwardedIdentityHeader): "",
+	}
+	for name, want := range wantHeaders {
+		if got := rec.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestGatewayStatusMapping(t *testing.T) {
+	_, gw := newGateway(t, &testService{}, nil)
+
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		wantHTTP int
+		wantCode codes.Code
+	}{
+		{name: "not found", method: "UnaryCall", body: `{"responseStatus":{"code":5,"message":"missing"}}`, wantHTTP: http.StatusNotFound, wantCode: codes.NotFound},
+		{name: "permission denied", method: "UnaryCall", body: `{"responseStatus":{"code":7}}`, wantHTTP: http.StatusForbidden, wantCode: codes.PermissionDenied},
+		{name: "unavailable", method: "UnaryCall", body: `{"responseStatus":{"code":14}}`, wantHTTP: http.StatusServiceUnavailable, wantCode: codes.Unavailable},
+		{name: "streaming method", method: "StreamingOutputCall", wantHTTP: http.StatusNotImplemented, wantCode: codes.Unimplemented},
+		{name: "unimplemented handler", method: "UnimplementedCall", wantHTTP: http.StatusNotImplemented, wantCode: codes.Unimplemented},
+		{name: "invalid body", method: "UnaryCall", body: `{"payload":`, wantHTTP: http.StatusBadRequest, wantCode: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := post(gw, tt.method, tt.body, nil)
+			if rec.Code != tt.wantHTTP {
+				t.Errorf("HTTP status = %d, want %d", rec.Code, tt.wantHTTP)
+			}
+
+			var body struct{ Code codes.Code }
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid error body %s: %s", rec.Body, err)
+			}
+			if body.Code != tt.wantCode {
+				t.Errorf("code = %s, want %s", body.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestGatewayDrain(t *testing.T) {
+	service := &testService{started: make(chan struct{}), release: make(chan struct{})}
+	server, gw := newGateway(t, service, grpctest.Config{"shutdown.drainTimeout": "10s"})
+
+	inFlight := make(chan *httptest.ResponseRecorder)
+	go func() {
+		inFlight <- post(gw, "UnaryCall", `{}`, nil)
+	}()
+	<-service.started
+
+	stopped := make(chan struct{})
+	go func() {
+		server.Stop()
+		close(stopped)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := post(gw, "EmptyCall", `{}`, nil)
+		if rec.Code == http.StatusServiceUnavailable {
+			break
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request while draining: status %d, body %s", rec.Code, rec.Body)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("new requests were still accepted while draining")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(service.release)
+	if rec := <-inFlight; rec.Code != http.StatusOK {
+		t.Errorf("in-flight request: status %d, body %s", rec.Code, rec.Body)
+	}
+	<-stopped
+}
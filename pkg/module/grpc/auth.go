@@ -0,0 +1,503 @@
+package grpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNoCredentials is returned by an AuthProvider when the request carries no
+// credentials it understands, so the next provider should be tried.
+var ErrNoCredentials = errors.New("no credentials")
+
+// ErrInvalidCredentials is returned by an AuthProvider when the request
+// carries credentials it understands but they don't match. Like any other
+// error, it doesn't stop later providers from being tried.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Methods that stay reachable without credentials so that health checks keep
+// working when authentication is enabled. Reflection exposes the full API
+// schema, so it is only public when listed in publicMethods.
+var defaultPublicMethods = []string{"/grpc.health.v1.Health/*"}
+
+type principalKey struct{}
+
+type Principal struct {
+	Name     string
+	Provider string
+	Claims   map[string]interface{}
+}
+
+// PrincipalFromContext returns the principal authenticated for the current
+// request, if any.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+type AuthProvider interface {
+	Authenticate(ctx context.Context) (*Principal, error)
+}
+
+type APIKey struct {
+	Name string
+	Key  string
+}
+
+type MethodRule struct {
+	Method     string
+	Principals []string
+}
+
+type JWTConfig struct {
+	Secret    string
+	JWKSFile  string
+	Issuer    string
+	Audience  string
+	ClockSkew time.Duration
+}
+
+type AuthConfig struct {
+	Enabled       bool
+	APIKeyHeader  string
+	APIKeys       []APIKey
+	JWT           *JWTConfig
+	PeerIdentity  bool
+	PublicMethods []string
+	Methods       []MethodRule
+}
+
+func parseAuthConfig(configPrefix string) (*AuthConfig, error) {
+	authPrefix := fmt.Sprintf("%s.auth", configPrefix)
+
+	if !viper.GetBool(fmt.Sprintf("%s.enabled", authPrefix)) {
+		return &AuthConfig{}, nil
+	}
+
+	config := &AuthConfig{
+		Enabled:       true,
+		APIKeyHeader:  viper.GetString(fmt.Sprintf("%s.apiKeyHeader", authPrefix)),
+		PeerIdentity:  viper.GetBool(fmt.Sprintf("%s.peerIdentity", authPrefix)),
+		PublicMethods: viper.GetStringSlice(fmt.Sprintf("%s.publicMethods", authPrefix)),
+	}
+
+	if config.APIKeyHeader == "" {
+		config.APIKeyHeader = "x-api-key"
+	}
+
+	err := viper.UnmarshalKey(fmt.Sprintf("%s.apiKeys", authPrefix), &config.APIKeys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %s.apiKeys: %s", authPrefix, err)
+	}
+	for _, key := range config.APIKeys {
+		if key.Name == "" || key.Key == "" {
+			return nil, fmt.Errorf("invalid configuration: %s.apiKeys entries need both name and key", authPrefix)
+		}
+	}
+
+	err = viper.UnmarshalKey(fmt.Sprintf("%s.methods", authPrefix), &config.Methods)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %s.methods: %s", authPrefix, err)
+	}
+	for _, rule := range config.Methods {
+		if rule.Method == "" {
+			return nil, fmt.Errorf("invalid configuration: %s.methods entries need a method", authPrefix)
+		}
+	}
+
+	jwtPrefix := fmt.Sprintf("%s.jwt", authPrefix)
+	if viper.IsSet(jwtPrefix) {
+		jwtConfig := &JWTConfig{
+			Secret:    viper.GetString(fmt.Sprintf("%s.secret", jwtPrefix)),
+			JWKSFile:  viper.GetString(fmt.Sprintf("%s.jwksFile", jwtPrefix)),
+			Issuer:    viper.GetString(fmt.Sprintf("%s.issuer", jwtPrefix)),
+			Audience:  viper.GetString(fmt.Sprintf("%s.audience", jwtPrefix)),
+			ClockSkew: viper.GetDuration(fmt.Sprintf("%s.clockSkew", jwtPrefix)),
+		}
+
+		if (jwtConfig.Secret == "") == (jwtConfig.JWKSFile == "") {
+			return nil, fmt.Errorf("invalid configuration: exactly one of %s.secret and %s.jwksFile must be set", jwtPrefix, jwtPrefix)
+		}
+		if jwtConfig.ClockSkew < 0 {
+			return nil, fmt.Errorf("invalid configuration: %s.clockSkew can't be less than 0", jwtPrefix)
+		}
+
+		config.JWT = jwtConfig
+	}
+
+	return config, nil
+}
+
+func incomingHeader(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func bearerToken(ctx context.Context) string {
+	header := incomingHeader(ctx, "authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+type apiKeyProvider struct {
+	header string
+	keys   []APIKey
+}
+
+func (a *apiKeyProvider) Authenticate(ctx context.Context) (*Principal, error) {
+	key := incomingHeader(ctx, a.header)
+	if key == "" {
+		key = bearerToken(ctx)
+	}
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	for _, candidate := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(candidate.Key), []byte(key)) == 1 {
+			return &Principal{Name: candidate.Name, Provider: "apikey"}, nil
+		}
+	}
+
+	return nil, ErrInvalidCredentials
+}
+
+type jwtProvider struct {
+	keyFunc jwt.Keyfunc
+	parser  *jwt.Parser
+}
+
+func newJWTProvider(config *JWTConfig) (*jwtProvider, error) {
+	options := []jwt.ParserOption{jwt.WithLeeway(config.ClockSkew), jwt.WithExpirationRequired()}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+
+	provider := &jwtProvider{}
+
+	if config.Secret != "" {
+		secret := []byte(config.Secret)
+		options = append(options, jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}))
+		provider.keyFunc = func(_ *jwt.Token) (interface{}, error) {
+			return secret, nil
+		}
+	} else {
+		keys, err := loadJWKS(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}))
+		provider.keyFunc = func(token *jwt.Token) (interface{}, error) {
+			if kid, ok := token.Header["kid"].(string); ok {
+				key, ok := keys[kid]
+				if !ok {
+					return nil, fmt.Errorf("unknown key id %s", kid)
+				}
+				return key, nil
+			}
+			set := jwt.VerificationKeySet{}
+			for _, key := range keys {
+				set.Keys = append(set.Keys, key)
+			}
+			return set, nil
+		}
+	}
+
+	provider.parser = jwt.NewParser(options...)
+	return provider, nil
+}
+
+func (j *jwtProvider) Authenticate(ctx context.Context) (*Principal, error) {
+	raw := bearerToken(ctx)
+	if raw == "" {
+		return nil, ErrNoCredentials
+	}
+	if strings.Count(raw, ".") != 2 {
+		return nil, ErrInvalidCredentials
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := j.parser.ParseWithClaims(raw, claims, j.keyFunc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	subject, _ := claims.GetSubject()
+	return &Principal{Name: subject, Provider: "jwt", Claims: claims}, nil
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func loadJWKS(path string) (map[string]jwt.VerificationKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS file: %w", err)
+	}
+
+	keys := make(map[string]jwt.VerificationKey)
+	for i, key := range set.Keys {
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %d in JWKS file: %w", i, err)
+		}
+		kid := key.Kid
+		if kid == "" {
+			kid = fmt.Sprintf("#%d", i)
+		}
+		keys[kid] = publicKey
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys in JWKS file %s", path)
+	}
+
+	return keys, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func (k jwk) publicKey() (jwt.VerificationKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+type peerIdentityProvider struct{}
+
+func (peerIdentityProvider) Authenticate(ctx context.Context) (*Principal, error) {
+	if identity, ok := forwardedIdentityFromContext(ctx); ok {
+		return &Principal{Name: identity, Provider: "mtls"}, nil
+	}
+
+	pr, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	tlsInfo, ok := pr.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	name := CertificateIdentity(tlsInfo.State.VerifiedChains[0][0])
+	if name == "" {
+		return nil, ErrNoCredentials
+	}
+
+	return &Principal{Name: name, Provider: "mtls"}, nil
+}
+
+// CertificateIdentity returns the name a client certificate authenticates as:
+// its first URI SAN, common name or first DNS SAN.
+func CertificateIdentity(cert *x509.Certificate) string {
+	switch {
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	}
+	return ""
+}
+
+type authenticator struct {
+	name          string
+	config        *AuthConfig
+	publicMethods []string
+	providers     []AuthProvider
+}
+
+func (p *GRPCServerModule) newAuthenticator() (*authenticator, error) {
+	a := &authenticator{name: p.GetName(), config: p.authConfig}
+
+	a.publicMethods = append(a.publicMethods, p.authConfig.PublicMethods...)
+	a.publicMethods = append(a.publicMethods, defaultPublicMethods...)
+
+	if p.authConfig.PeerIdentity {
+		a.providers = append(a.providers, peerIdentityProvider{})
+	}
+
+	if p.authConfig.JWT != nil {
+		provider, err := newJWTProvider(p.authConfig.JWT)
+		if err != nil {
+			return nil, err
+		}
+		a.providers = append(a.providers, provider)
+	}
+
+	if len(p.authConfig.APIKeys) > 0 {
+		a.providers = append(a.providers, &apiKeyProvider{header: p.authConfig.APIKeyHeader, keys: p.authConfig.APIKeys})
+	}
+
+	a.providers = append(a.providers, p.options.AuthProviders...)
+
+	if len(a.providers) == 0 {
+		return nil, fmt.Errorf("authentication is enabled for GRPC server %s but no providers are configured", p.GetName())
+	}
+
+	return a, nil
+}
+
+func matchMethod(pattern string, method string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(method, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == method
+}
+
+func (a *authenticator) isPublic(method string) bool {
+	for _, pattern := range a.publicMethods {
+		if matchMethod(pattern, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *authenticator) isAllowed(principal *Principal, method string) bool {
+	for _, rule := range a.config.Methods {
+		if !matchMethod(rule.Method, method) {
+			continue
+		}
+		for _, allowed := range rule.Principals {
+			if allowed == "*" || allowed == principal.Name {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (a *authenticator) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if a.isPublic(method) {
+		return ctx, nil
+	}
+
+	var failures []error
+	for _, provider := range a.providers {
+		principal, err := provider.Authenticate(ctx)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			failures = append(failures, err)
+			continue
+		}
+
+		if !a.isAllowed(principal, method) {
+			log.Warn("GRPC principal is not allowed to call method", "name", a.name, "method", method, "principal", principal.Name, "provider", principal.Provider)
+			return nil, status.Errorf(codes.PermissionDenied, "%s is not allowed to call %s", principal.Name, method)
+		}
+
+		return context.WithValue(ctx, principalKey{}, principal), nil
+	}
+
+	if len(failures) > 0 {
+		log.Warn("GRPC authentication failed", "name", a.name, "method", method, "error", errors.Join(failures...))
+		return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+	}
+
+	return nil, status.Error(codes.Unauthenticated, "missing credentials")
+}
+
+func (a *authenticator) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (a *authenticator) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, wrapServerStream(ss, ctx))
+	}
+}
@@ -0,0 +1,143 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const testJWTSecret = "test-secret"
+
+// headerProvider accepts requests carrying x-test-user.
+type headerProvider struct{}
+
+func (headerProvider) Authenticate(ctx context.Context) (*Principal, error) {
+	user := incomingHeader(ctx, "x-test-user")
+	if user == "" {
+		return nil, ErrNoCredentials
+	}
+	return &Principal{Name: user, Provider: "header"}, nil
+}
+
+// failingProvider fails requests carrying x-test-fail with an error other than
+// ErrInvalidCredentials.
+type failingProvider struct{}
+
+func (failingProvider) Authenticate(ctx context.Context) (*Principal, error) {
+	if incomingHeader(ctx, "x-test-fail") == "" {
+		return nil, ErrNoCredentials
+	}
+	return nil, errors.New("identity service unavailable")
+}
+
+func signedToken(t *testing.T, subject string, expiresIn time.Duration) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": subject,
+		"exp": time.Now().Add(expiresIn).Unix(),
+	})
+	signed, err := token.SignedString([]byte(testJWTSecret))
+	if err != nil {
+		t.Fatalf("failed to sign token: %s", err)
+	}
+	return signed
+}
+
+func newTestAuthenticator(t *testing.T, publicMethods ...string) *authenticator {
+	t.Helper()
+	p := NewGRPCServerModule("test", &Options{AuthProviders: []AuthProvider{failingProvider{}, headerProvider{}}})
+	p.reflection = true
+	p.authConfig = &AuthConfig{
+		Enabled:       true,
+		APIKeyHeader:  "x-api-key",
+		APIKeys:       []APIKey{{Name: "batch", Key: "batch-key"}},
+		JWT:           &JWTConfig{Secret: testJWTSecret},
+		PeerIdentity:  true,
+		PublicMethods: append([]string{"/test.Public/*"}, publicMethods...),
+		Methods:       []MethodRule{{Method: "/test.Admin/*", Principals: []string{"admin"}}},
+	}
+	a, err := p.newAuthenticator()
+	if err != nil {
+		t.Fatalf("failed to create authenticator: %s", err)
+	}
+	return a
+}
+
+func TestAuthenticatorProviderSelection(t *testing.T) {
+	tests := []struct {
+		name          string
+		publicMethods []string
+		inProcess     bool
+		forwardedFor  string
+		method        string
+		headers       []string
+		wantCode      codes.Code
+		wantMessage   string
+		wantPrincipal string
+		wantProvider  string
+	}{
+		{name: "health is public", method: "/grpc.health.v1.Health/Check", wantCode: codes.OK},
+		{name: "configured public method", method: "/test.Public/Get", wantCode: codes.OK},
+		{name: "reflection is public when listed", publicMethods: []string{"/grpc.reflection.v1alpha.ServerReflection/*"}, method: "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", wantCode: codes.OK},
+		{name: "reflection needs credentials by default", method: "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", wantCode: codes.Unauthenticated, wantMessage: "missing credentials"},
+		{name: "missing credentials", method: "/test.Service/Get", wantCode: codes.Unauthenticated, wantMessage: "missing credentials"},
+		{name: "api key", method: "/test.Service/Get", headers: []string{"x-api-key", "batch-key"}, wantPrincipal: "batch", wantProvider: "apikey"},
+		{name: "api key as bearer token", method: "/test.Service/Get", headers: []string{"authorization", "Bearer batch-key"}, wantPrincipal: "batch", wantProvider: "apikey"},
+		{name: "invalid api key", method: "/test.Service/Get", headers: []string{"x-api-key", "wrong"}, wantCode: codes.Unauthenticated, wantMessage: "invalid credentials"},
+		{name: "invalid opaque bearer token", method: "/test.Service/Get", headers: []string{"authorization", "Bearer wrong"}, wantCode: codes.Unauthenticated, wantMessage: "invalid credentials"},
+		{name: "jwt", method: "/test.Service/Get", headers: []string{"authorization", "Bearer " + signedToken(t, "alice", time.Minute)}, wantPrincipal: "alice", wantProvider: "jwt"},
+		{name: "expired jwt", method: "/test.Service/Get", headers: []string{"authorization", "Bearer " + signedToken(t, "alice", -time.Minute)}, wantCode: codes.Unauthenticated, wantMessage: "invalid credentials"},
+		{name: "forwarded peer identity in process", inProcess: true, forwardedFor: "spiffe://cluster/client", method: "/test.Service/Get", headers: []string{"x-api-key", "batch-key"}, wantPrincipal: "spiffe://cluster/client", wantProvider: "mtls"},
+		{name: "forwarded peer identity over the network", forwardedFor: "spiffe://cluster/client", method: "/test.Service/Get", headers: []string{"x-api-key", "batch-key"}, wantPrincipal: "batch", wantProvider: "apikey"},
+		{name: "forwarded identity header without token", inProcess: true, method: "/test.Service/Get", headers: []string{ForwardedIdentityHeader, "spiffe://cluster/client", "x-api-key", "batch-key"}, wantPrincipal: "batch", wantProvider: "apikey"},
+		{name: "custom provider", method: "/test.Service/Get", headers: []string{"x-test-user", "bob"}, wantPrincipal: "bob", wantProvider: "header"},
+		{name: "custom provider after invalid api key", method: "/test.Service/Get", headers: []string{"x-api-key", "wrong", "x-test-user", "bob"}, wantPrincipal: "bob", wantProvider: "header"},
+		{name: "custom provider after failing provider", method: "/test.Service/Get", headers: []string{"x-test-fail", "1", "x-test-user", "bob"}, wantPrincipal: "bob", wantProvider: "header"},
+		{name: "failing provider", method: "/test.Service/Get", headers: []string{"x-test-fail", "1"}, wantCode: codes.Unauthenticated, wantMessage: "invalid credentials"},
+		{name: "method rule allows", method: "/test.Admin/Delete", headers: []string{"x-test-user", "admin"}, wantPrincipal: "admin", wantProvider: "header"},
+		{name: "method rule denies", method: "/test.Admin/Delete", headers: []string{"x-api-key", "batch-key"}, wantCode: codes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAuthenticator(t, tt.publicMethods...)
+			server := NewGRPCServerModule("forwarder", &Options{})
+			server.EnableInProcess()
+
+			md := metadata.Pairs(tt.headers...)
+			if tt.forwardedFor != "" {
+				server.ForwardCaller(md, "10.0.0.1", tt.forwardedFor)
+			}
+			ctx := metadata.NewIncomingContext(context.Background(), md)
+			if tt.inProcess {
+				ctx = withForwardedPeer(ctx, server.forwardingToken)
+			}
+
+			ctx, err := a.authenticate(ctx, tt.method)
+			st := status.Convert(err)
+			if st.Code() != tt.wantCode {
+				t.Fatalf("authenticate() code = %s (%s), want %s", st.Code(), st.Message(), tt.wantCode)
+			}
+			if tt.wantMessage != "" && st.Message() != tt.wantMessage {
+				t.Errorf("authenticate() message = %q, want %q", st.Message(), tt.wantMessage)
+			}
+			if err != nil || tt.wantProvider == "" {
+				return
+			}
+
+			principal, ok := PrincipalFromContext(ctx)
+			if !ok {
+				t.Fatal("no principal in context")
+			}
+			if principal.Name != tt.wantPrincipal || principal.Provider != tt.wantProvider {
+				t.Errorf("principal = %s/%s, want %s/%s", principal.Provider, principal.Name, tt.wantProvider, tt.wantPrincipal)
+			}
+		})
+	}
+}
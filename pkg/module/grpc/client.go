@@ -0,0 +1,340 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	grpcprom "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/keepalive"
+
+	"github.com/dnikishov/microboiler/pkg/module"
+)
+
+var loadBalancingPolicies = map[string]bool{
+	"pick_first":  true,
+	"round_robin": true,
+}
+
+type ClientTLSConfig struct {
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+}
+
+type ClientRetryPolicy struct {
+	MaxAttempts          int
+	InitialBackoff       time.Duration
+	MaxBackoff           time.Duration
+	BackoffMultiplier    float64
+	RetryableStatusCodes []string
+}
+
+type ClientConfig struct {
+	Target          string
+	TLS             *ClientTLSConfig
+	Keepalive       keepalive.ClientParameters
+	DefaultDeadline time.Duration
+	ReadyTimeout    time.Duration
+	LoadBalancing   string
+	Compression     string
+	Retry           *ClientRetryPolicy
+	ExportMetrics   bool
+}
+
+type ClientOptions struct {
+	UnaryInterceptors  []grpc.UnaryClientInterceptor
+	StreamInterceptors []grpc.StreamClientInterceptor
+	DialOptions        []grpc.DialOption
+}
+
+type GRPCClientModule struct {
+	module.Base
+	options *ClientOptions
+	config  *ClientConfig
+	conn    *grpc.ClientConn
+
+	Metrics       *grpcprom.ClientMetrics
+	ModuleMetrics *ClientModuleMetrics
+}
+
+func (p *GRPCClientModule) Configure() error {
+	configPrefix := fmt.Sprintf("grpc-client-%s", p.GetName())
+
+	config := &ClientConfig{
+		Target:          viper.GetString(fmt.Sprintf("%s.target", configPrefix)),
+		DefaultDeadline: viper.GetDuration(fmt.Sprintf("%s.defaultDeadline", configPrefix)),
+		ReadyTimeout:    viper.GetDuration(fmt.Sprintf("%s.readyTimeout", configPrefix)),
+		LoadBalancing:   viper.GetString(fmt.Sprintf("%s.loadBalancing", configPrefix)),
+		Compression:     viper.GetString(fmt.Sprintf("%s.compression", configPrefix)),
+		ExportMetrics:   viper.GetBool(fmt.Sprintf("%s.exportMetrics", configPrefix)),
+	}
+
+	if config.Target == "" {
+		return fmt.Errorf("invalid configuration: %s.target is not set", configPrefix)
+	}
+
+	config.Keepalive.Time = viper.GetDuration(fmt.Sprintf("%s.keepalive.time", configPrefix))
+	config.Keepalive.Timeout = viper.GetDuration(fmt.Sprintf("%s.keepalive.timeout", configPrefix))
+	config.Keepalive.PermitWithoutStream = viper.GetBool(fmt.Sprintf("%s.keepalive.permitWithoutStream", configPrefix))
+
+	if config.DefaultDeadline < 0 {
+		return fmt.Errorf("invalid configuration: %s.defaultDeadline can't be less than 0", configPrefix)
+	}
+
+	if config.ReadyTimeout == 0 {
+		config.ReadyTimeout = 5 * time.Second
+	} else if config.ReadyTimeout < 0 {
+		return fmt.Errorf("invalid configuration: %s.readyTimeout can't be less than 0", configPrefix)
+	}
+
+	if config.LoadBalancing == "" {
+		config.LoadBalancing = "pick_first"
+	} else if !loadBalancingPolicies[config.LoadBalancing] {
+		return fmt.Errorf("invalid configuration: %s.loadBalancing must be pick_first or round_robin", configPrefix)
+	}
+
+	if config.Compression != "" && encoding.GetCompressor(config.Compression) == nil {
+		return fmt.Errorf("invalid configuration: unknown compressor %s in %s.compression", config.Compression, configPrefix)
+	}
+
+	tlsPrefix := fmt.Sprintf("%s.tls", configPrefix)
+	if viper.GetBool(fmt.Sprintf("%s.enabled", tlsPrefix)) {
+		config.TLS = &ClientTLSConfig{
+			CAFile:     viper.GetString(fmt.Sprintf("%s.caFile", tlsPrefix)),
+			CertFile:   viper.GetString(fmt.Sprintf("%s.certFile", tlsPrefix)),
+			KeyFile:    viper.GetString(fmt.Sprintf("%s.keyFile", tlsPrefix)),
+			ServerName: viper.GetString(fmt.Sprintf("%s.serverName", tlsPrefix)),
+		}
+		if (config.TLS.CertFile == "") != (config.TLS.KeyFile == "") {
+			return fmt.Errorf("invalid configuration: both %s.certFile and %s.keyFile must be set", tlsPrefix, tlsPrefix)
+		}
+	}
+
+	retryPrefix := fmt.Sprintf("%s.retry", configPrefix)
+	if viper.IsSet(retryPrefix) {
+		retry := &ClientRetryPolicy{
+			MaxAttempts:          viper.GetInt(fmt.Sprintf("%s.maxAttempts", retryPrefix)),
+			InitialBackoff:       viper.GetDuration(fmt.Sprintf("%s.initialBackoff", retryPrefix)),
+			MaxBackoff:           viper.GetDuration(fmt.Sprintf("%s.maxBackoff", retryPrefix)),
+			BackoffMultiplier:    viper.GetFloat64(fmt.Sprintf("%s.backoffMultiplier", retryPrefix)),
+			RetryableStatusCodes: viper.GetStringSlice(fmt.Sprintf("%s.retryableStatusCodes", retryPrefix)),
+		}
+
+		if retry.MaxAttempts < 2 {
+			return fmt.Errorf("invalid configuration: %s.maxAttempts must be at least 2", retryPrefix)
+		}
+		if retry.InitialBackoff == 0 {
+			retry.InitialBackoff = 100 * time.Millisecond
+		}
+		if retry.MaxBackoff == 0 {
+			retry.MaxBackoff = time.Second
+		}
+		if retry.BackoffMultiplier == 0 {
+			retry.BackoffMultiplier = 2
+		}
+		if retry.InitialBackoff < 0 || retry.MaxBackoff < retry.InitialBackoff || retry.BackoffMultiplier <= 0 {
+			return fmt.Errorf("invalid configuration: %s has invalid backoff settings", retryPrefix)
+		}
+		if len(retry.RetryableStatusCodes) == 0 {
+			retry.RetryableStatusCodes = []string{"UNAVAILABLE"}
+		}
+		for i, code := range retry.RetryableStatusCodes {
+			retry.RetryableStatusCodes[i] = strings.ToUpper(code)
+		}
+
+		config.Retry = retry
+	}
+
+	p.config = config
+
+	if config.ExportMetrics {
+		module.RegisterCollector(fmt.Sprintf("grpc-client-%s", p.GetName()), p.Metrics)
+		module.RegisterCollector(fmt.Sprintf("grpc-client-%s-module", p.GetName()), p.ModuleMetrics)
+	}
+
+	return nil
+}
+
+func (p *GRPCClientModule) serviceConfig() (string, error) {
+	serviceConfig := map[string]interface{}{
+		"loadBalancingConfig": []map[string]interface{}{{p.config.LoadBalancing: map[string]interface{}{}}},
+	}
+
+	if p.config.Retry != nil {
+		serviceConfig["methodConfig"] = []map[string]interface{}{{
+			"name": []map[string]interface{}{{}},
+			"retryPolicy": map[string]interface{}{
+				"maxAttempts":          p.config.Retry.MaxAttempts,
+				"initialBackoff":       fmt.Sprintf("%.9fs", p.config.Retry.InitialBackoff.Seconds()),
+				"maxBackoff":           fmt.Sprintf("%.9fs", p.config.Retry.MaxBackoff.Seconds()),
+				"backoffMultiplier":    p.config.Retry.BackoffMultiplier,
+				"retryableStatusCodes": p.config.Retry.RetryableStatusCodes,
+			},
+		}}
+	}
+
+	data, err := json.Marshal(serviceConfig)
+	return string(data), err
+}
+
+func (p *GRPCClientModule) transportCredentials() (credentials.TransportCredentials, error) {
+	if p.config.TLS == nil {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsConfig := &tls.Config{ServerName: p.config.TLS.ServerName, MinVersion: tls.VersionTLS12}
+
+	if p.config.TLS.CAFile != "" {
+		pem, err := os.ReadFile(p.config.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", p.config.TLS.CAFile)
+		}
+	}
+
+	if p.config.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(p.config.TLS.CertFile, p.config.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client key pair: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+func (p *GRPCClientModule) defaultDeadlineInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, p.config.DefaultDeadline)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func (p *GRPCClientModule) Init(ctx context.Context) error {
+	creds, err := p.transportCredentials()
+	if err != nil {
+		return err
+	}
+
+	serviceConfig, err := p.serviceConfig()
+	if err != nil {
+		return err
+	}
+
+	dialOptions := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultServiceConfig(serviceConfig),
+	}
+
+	if p.config.Keepalive.Time > 0 {
+		dialOptions = append(dialOptions, grpc.WithKeepaliveParams(p.config.Keepalive))
+	}
+
+	if p.config.Compression != "" {
+		dialOptions = append(dialOptions, grpc.WithDefaultCallOptions(grpc.UseCompressor(p.config.Compression)))
+	}
+
+	unaryInterceptors := []grpc.UnaryClientInterceptor{}
+	streamInterceptors := []grpc.StreamClientInterceptor{}
+
+	if p.config.ExportMetrics {
+		unaryInterceptors = append(unaryInterceptors, p.Metrics.UnaryClientInterceptor())
+		streamInterceptors = append(streamInterceptors, p.Metrics.StreamClientInterceptor())
+		dialOptions = append(dialOptions, grpc.WithStatsHandler(&compressionHandler{ratio: p.ModuleMetrics.compressionRatio}))
+	}
+
+	if p.config.DefaultDeadline > 0 {
+		unaryInterceptors = append(unaryInterceptors, p.defaultDeadlineInterceptor())
+	}
+
+	unaryInterceptors = append(unaryInterceptors, p.options.UnaryInterceptors...)
+	streamInterceptors = append(streamInterceptors, p.options.StreamInterceptors...)
+
+	dialOptions = append(dialOptions,
+		grpc.WithChainUnaryInterceptor(unaryInterceptors...),
+		grpc.WithChainStreamInterceptor(streamInterceptors...),
+	)
+	dialOptions = append(dialOptions, p.options.DialOptions...)
+
+	p.conn, err = grpc.DialContext(ctx, p.config.Target, dialOptions...)
+	if err != nil {
+		return fmt.Errorf("failed to dial %s: %w", p.config.Target, err)
+	}
+
+	err = p.waitForReady(ctx)
+	if err != nil {
+		p.conn.Close()
+		return err
+	}
+
+	log.Info("GRPC client initialized", "name", p.GetName(), "target", p.config.Target, "load_balancing", p.config.LoadBalancing)
+
+	return nil
+}
+
+func (p *GRPCClientModule) waitForReady(ctx context.Context) error {
+	readyCtx, cancel := context.WithTimeout(ctx, p.config.ReadyTimeout)
+	defer cancel()
+
+	p.conn.Connect()
+	for {
+		state := p.conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if !p.conn.WaitForStateChange(readyCtx, state) {
+			return fmt.Errorf("GRPC client %s did not become ready within %s, last state %s", p.GetName(), p.config.ReadyTimeout, state)
+		}
+	}
+}
+
+func (p *GRPCClientModule) Cleanup(_ context.Context) {
+	log.Info("Closing GRPC client", "name", p.GetName())
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
+
+func (p *GRPCClientModule) GetConn() *grpc.ClientConn {
+	return p.conn
+}
+
+func NewGRPCClientModule(name string, options *ClientOptions) *GRPCClientModule {
+	if options == nil {
+		options = &ClientOptions{}
+	}
+	metrics := grpcprom.NewClientMetrics(
+		grpcprom.WithClientCounterOptions(grpcprom.WithConstLabels(prometheus.Labels{"app": name})),
+		grpcprom.WithClientHandlingTimeHistogram(
+			grpcprom.WithHistogramBuckets([]float64{0.001, 0.01, 0.1, 0.3, 0.6, 1, 3, 6, 9, 20, 30, 60, 90, 120}),
+		),
+	)
+	return &GRPCClientModule{
+		Base:          module.Base{Name: name, IncludesInit: true, IncludesCleanup: true},
+		options:       options,
+		Metrics:       metrics,
+		ModuleMetrics: newClientModuleMetrics(name),
+	}
+}
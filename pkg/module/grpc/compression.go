@@ -0,0 +1,189 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	compressionDirectionReceived = "received"
+	compressionDirectionSent     = "sent"
+)
+
+// Requests without compression use the identity encoding, which is always
+// accepted.
+const identityCompressor = "identity"
+
+type CompressionConfig struct {
+	// Accepted lists the compressors clients may use for requests; empty
+	// means every registered compressor.
+	Accepted []string
+	// Default is used for responses when the client supports it and did not
+	// compress its request with something else.
+	Default string
+}
+
+func parseCompressionConfig(configPrefix string) (*CompressionConfig, error) {
+	compressionPrefix := fmt.Sprintf("%s.compression", configPrefix)
+
+	config := &CompressionConfig{
+		Accepted: viper.GetStringSlice(fmt.Sprintf("%s.accepted", compressionPrefix)),
+		Default:  viper.GetString(fmt.Sprintf("%s.default", compressionPrefix)),
+	}
+
+	for _, name := range config.Accepted {
+		if name != identityCompressor && encoding.GetCompressor(name) == nil {
+			return nil, fmt.Errorf("invalid configuration: unknown compressor %s in %s.accepted", name, compressionPrefix)
+		}
+	}
+
+	if config.Default != "" {
+		if encoding.GetCompressor(config.Default) == nil {
+			return nil, fmt.Errorf("invalid configuration: unknown compressor %s in %s.default", config.Default, compressionPrefix)
+		}
+		if !config.accepts(config.Default) {
+			return nil, fmt.Errorf("invalid configuration: %s.default must be one of %s.accepted", compressionPrefix, compressionPrefix)
+		}
+	}
+
+	return config, nil
+}
+
+func (c *CompressionConfig) enabled() bool {
+	return len(c.Accepted) > 0 || c.Default != ""
+}
+
+func (c *CompressionConfig) accepts(name string) bool {
+	if len(c.Accepted) == 0 || name == "" || name == identityCompressor {
+		return true
+	}
+	for _, accepted := range c.Accepted {
+		if accepted == name {
+			return true
+		}
+	}
+	return false
+}
+
+type compressionStateKey struct{}
+
+// compressionState carries the compressors of a single RPC from the stats
+// handler, which sees the headers, to the interceptors.
+type compressionState struct {
+	received string
+	sent     string
+}
+
+func compressionStateFromContext(ctx context.Context) *compressionState {
+	state, _ := ctx.Value(compressionStateKey{}).(*compressionState)
+	return state
+}
+
+// compressionHandler records the compression ratio of every compressed
+// message, on servers and clients alike.
+type compressionHandler struct {
+	ratio *prometheus.HistogramVec
+}
+
+func (h *compressionHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, compressionStateKey{}, &compressionState{})
+}
+
+func (h *compressionHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	state := compressionStateFromContext(ctx)
+	if state == nil {
+		return
+	}
+
+	switch s := s.(type) {
+	case *stats.InHeader:
+		state.received = s.Compression
+	case *stats.OutHeader:
+		state.sent = s.Compression
+	case *stats.InPayload:
+		h.observe(compressionDirectionReceived, state.received, s.Length, s.CompressedLength)
+	case *stats.OutPayload:
+		h.observe(compressionDirectionSent, state.sent, s.Length, s.CompressedLength)
+	}
+}
+
+func (h *compressionHandler) observe(direction string, compressor string, length int, compressedLength int) {
+	if compressor == "" || compressor == identityCompressor || compressedLength == 0 {
+		return
+	}
+	h.ratio.WithLabelValues(direction, compressor).Observe(float64(length) / float64(compressedLength))
+}
+
+func (h *compressionHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *compressionHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+// compressionEnforcer runs before every other interceptor, so requests
+// compressed with a compressor that is not accepted are always rejected with
+// Unimplemented, like those using an unknown one. grpc-go has read the request
+// by then, within the decoder memory limits.
+type compressionEnforcer struct {
+	config *CompressionConfig
+}
+
+// apply rejects requests compressed with a compressor that is not accepted and
+// selects the default compressor for the response.
+func (e *compressionEnforcer) apply(ctx context.Context) error {
+	state := compressionStateFromContext(ctx)
+	if state == nil {
+		return nil
+	}
+
+	if !e.config.accepts(state.received) {
+		return status.Errorf(codes.Unimplemented, "compressor %s is not accepted, use one of %s", state.received, strings.Join(e.config.Accepted, ","))
+	}
+
+	if e.config.Default == "" || (state.received != "" && state.received != identityCompressor) {
+		return nil
+	}
+
+	supported, err := grpc.ClientSupportedCompressors(ctx)
+	if err != nil {
+		return nil
+	}
+	for _, name := range supported {
+		if strings.TrimSpace(name) == e.config.Default {
+			grpc.SetSendCompressor(ctx, e.config.Default)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (e *compressionEnforcer) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		err := e.apply(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (e *compressionEnforcer) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := e.apply(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
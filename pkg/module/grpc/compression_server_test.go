@@ -0,0 +1,46 @@
+package grpc_test
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	grpcmod "github.com/dnikishov/microboiler/pkg/module/grpc"
+	"github.com/dnikishov/microboiler/pkg/module/grpc/grpctest"
+)
+
+func TestServerRejectsUnacceptedCompressor(t *testing.T) {
+	server := grpctest.NewServer(t, &grpcmod.Options{}, grpctest.Config{"compression.accepted": []string{"zstd"}})
+	client := healthpb.NewHealthClient(server.Conn)
+
+	tests := []struct {
+		name       string
+		compressor string
+		want       codes.Code
+	}{
+		{name: "uncompressed", want: codes.OK},
+		{name: "accepted", compressor: "zstd", want: codes.OK},
+		{name: "not accepted", compressor: "gzip", want: codes.Unimplemented},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []grpc.CallOption
+			if tt.compressor != "" {
+				opts = append(opts, grpc.UseCompressor(tt.compressor))
+			}
+			// The rejection must not depend on how far the server got with
+			// reading the request.
+			for i := 0; i < 50; i++ {
+				_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}, opts...)
+				if got := status.Code(err); got != tt.want {
+					t.Fatalf("call %d: got %s (%v), want %s", i+1, got, err, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/stats"
+)
+
+func TestCompressionHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []stats.RPCStats
+		want   [][2]string
+	}{
+		{
+			name: "server",
+			events: []stats.RPCStats{
+				&stats.InHeader{Compression: "gzip"},
+				&stats.InPayload{Length: 400, CompressedLength: 100},
+				&stats.OutHeader{Compression: "gzip"},
+				&stats.OutPayload{Length: 200, CompressedLength: 100},
+			},
+			want: [][2]string{{compressionDirectionReceived, "gzip"}, {compressionDirectionSent, "gzip"}},
+		},
+		{
+			name: "client",
+			events: []stats.RPCStats{
+				&stats.OutHeader{Client: true, Compression: "zstd"},
+				&stats.OutPayload{Client: true, Length: 400, CompressedLength: 100},
+				&stats.InHeader{Client: true, Compression: "gzip"},
+				&stats.InPayload{Client: true, Length: 200, CompressedLength: 100},
+			},
+			want: [][2]string{{compressionDirectionReceived, "gzip"}, {compressionDirectionSent, "zstd"}},
+		},
+		{
+			name: "uncompressed",
+			events: []stats.RPCStats{
+				&stats.InHeader{Compression: identityCompressor},
+				&stats.InPayload{Length: 100, CompressedLength: 100},
+				&stats.OutHeader{},
+				&stats.OutPayload{Length: 100, CompressedLength: 100},
+			},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := newClientModuleMetrics("test")
+			handler := &compressionHandler{ratio: metrics.compressionRatio}
+
+			ctx := handler.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "/test.Service/Get"})
+			for _, event := range tt.events {
+				handler.HandleRPC(ctx, event)
+			}
+
+			if got := testutil.CollectAndCount(metrics.compressionRatio); got != len(tt.want) {
+				t.Errorf("observed series = %d, want %d", got, len(tt.want))
+			}
+			for _, series := range tt.want {
+				if !metrics.compressionRatio.DeleteLabelValues(series[0], series[1]) {
+					t.Errorf("no %s %s observations", series[0], series[1])
+				}
+			}
+		})
+	}
+}
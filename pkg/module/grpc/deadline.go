@@ -0,0 +1,144 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+)
+
+type MethodTimeout struct {
+	Method  string
+	Timeout time.Duration
+}
+
+type DeadlineConfig struct {
+	Default time.Duration
+	Methods []MethodTimeout
+}
+
+func parseDeadlineConfig(configPrefix string) (*DeadlineConfig, error) {
+	deadlinesPrefix := fmt.Sprintf("%s.deadlines", configPrefix)
+
+	config := &DeadlineConfig{
+		Default: viper.GetDuration(fmt.Sprintf("%s.default", deadlinesPrefix)),
+	}
+
+	if config.Default < 0 {
+		return nil, fmt.Errorf("invalid configuration: %s.default can't be less than 0", deadlinesPrefix)
+	}
+
+	err := viper.UnmarshalKey(fmt.Sprintf("%s.methods", deadlinesPrefix), &config.Methods)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %s.methods: %s", deadlinesPrefix, err)
+	}
+
+	for _, method := range config.Methods {
+		if method.Method == "" {
+			return nil, fmt.Errorf("invalid configuration: %s.methods entries need a method", deadlinesPrefix)
+		}
+		if method.Timeout <= 0 {
+			return nil, fmt.Errorf("invalid configuration: %s.methods timeout for %s must be greater than 0", deadlinesPrefix, method.Method)
+		}
+	}
+
+	return config, nil
+}
+
+func (c *DeadlineConfig) enabled() bool {
+	return c.Default > 0 || len(c.Methods) > 0
+}
+
+// timeoutFor returns the timeout of the most specific matching method rule:
+// exact matches win over wildcards, longer wildcards over shorter ones.
+func (c *DeadlineConfig) timeoutFor(method string) (time.Duration, bool) {
+	bestLength := -1
+	var timeout time.Duration
+
+	for _, rule := range c.Methods {
+		if !matchMethod(rule.Method, method) {
+			continue
+		}
+		length := len(strings.TrimSuffix(rule.Method, "*"))
+		if rule.Method == method {
+			length = len(method) + 1
+		}
+		if length > bestLength {
+			bestLength = length
+			timeout = rule.Timeout
+		}
+	}
+
+	return timeout, bestLength >= 0
+}
+
+type deadlineEnforcer struct {
+	config  *DeadlineConfig
+	metrics *ModuleMetrics
+}
+
+type serverDeadlineKey struct{}
+
+// hasServerDeadline reports whether the deadline of ctx was imposed by the
+// server rather than requested by the client.
+func hasServerDeadline(ctx context.Context) bool {
+	imposed, _ := ctx.Value(serverDeadlineKey{}).(bool)
+	return imposed
+}
+
+// withDeadline caps ctx with timeout. context.WithTimeout keeps the client's
+// deadline when it is earlier, so the effective deadline is the minimum.
+func (d *deadlineEnforcer) withDeadline(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc, bool) {
+	deadline := time.Now().Add(timeout)
+	clientDeadline, hasClientDeadline := ctx.Deadline()
+	serverImposed := !hasClientDeadline || deadline.Before(clientDeadline)
+
+	ctx, cancel := context.WithDeadline(context.WithValue(ctx, serverDeadlineKey{}, serverImposed), deadline)
+	return ctx, cancel, serverImposed
+}
+
+func (d *deadlineEnforcer) observe(ctx context.Context, method string, serverImposed bool) {
+	if serverImposed && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		d.metrics.timeouts.WithLabelValues(method).Inc()
+	}
+}
+
+func (d *deadlineEnforcer) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		timeout, ok := d.config.timeoutFor(info.FullMethod)
+		if !ok {
+			timeout = d.config.Default
+		}
+		if timeout <= 0 {
+			return handler(ctx, req)
+		}
+
+		ctx, cancel, serverImposed := d.withDeadline(ctx, timeout)
+		defer cancel()
+
+		resp, err := handler(ctx, req)
+		d.observe(ctx, info.FullMethod, serverImposed)
+		return resp, err
+	}
+}
+
+// Streams are often long-lived, so only explicit per-method timeouts apply.
+func (d *deadlineEnforcer) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		timeout, ok := d.config.timeoutFor(info.FullMethod)
+		if !ok {
+			return handler(srv, ss)
+		}
+
+		ctx, cancel, serverImposed := d.withDeadline(ss.Context(), timeout)
+		defer cancel()
+
+		err := handler(srv, wrapServerStream(ss, ctx))
+		d.observe(ctx, info.FullMethod, serverImposed)
+		return err
+	}
+}
@@ -0,0 +1,189 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const drainLogInterval = time.Second
+
+type ShutdownConfig struct {
+	PreStopDelay time.Duration
+	DrainTimeout time.Duration
+}
+
+func parseShutdownConfig(configPrefix string) (ShutdownConfig, error) {
+	shutdownPrefix := fmt.Sprintf("%s.shutdown", configPrefix)
+
+	config := ShutdownConfig{
+		PreStopDelay: viper.GetDuration(fmt.Sprintf("%s.preStopDelay", shutdownPrefix)),
+		DrainTimeout: viper.GetDuration(fmt.Sprintf("%s.drainTimeout", shutdownPrefix)),
+	}
+
+	if config.PreStopDelay < 0 {
+		return config, fmt.Errorf("invalid configuration: %s.preStopDelay can't be less than 0", shutdownPrefix)
+	}
+
+	if config.DrainTimeout < 0 {
+		return config, fmt.Errorf("invalid configuration: %s.drainTimeout can't be less than 0", shutdownPrefix)
+	}
+
+	if config.DrainTimeout == 0 {
+		config.DrainTimeout = 30 * time.Second
+	}
+
+	return config, nil
+}
+
+// healthMethodPrefix matches the methods of the health service, which aren't
+// tracked as in flight: its Watch streams last as long as the connection.
+var healthMethodPrefix = "/" + healthpb.Health_ServiceDesc.ServiceName + "/"
+
+// inFlightTracker counts RPCs currently being handled, except health checks.
+// Its interceptors are installed outermost so that every accepted RPC is
+// counted.
+type inFlightTracker struct {
+	mu    sync.Mutex
+	count int64
+	// idle is closed when the count drops to zero while someone waits.
+	idle chan struct{}
+}
+
+func (t *inFlightTracker) add(delta int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.count += delta
+	if t.count == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+func (t *inFlightTracker) load() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.count
+}
+
+// idleCh returns a channel that is closed once no RPCs are in flight.
+func (t *inFlightTracker) idleCh() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.count == 0 {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	return t.idle
+}
+
+func (t *inFlightTracker) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			return handler(ctx, req)
+		}
+		t.add(1)
+		defer t.add(-1)
+		return handler(ctx, req)
+	}
+}
+
+func (t *inFlightTracker) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			return handler(srv, ss)
+		}
+		t.add(1)
+		defer t.add(-1)
+		return handler(srv, ss)
+	}
+}
+
+// drain stops the servers gracefully: health checks report NOT_SERVING, the
+// pre-stop delay gives load balancers time to notice, then health Watch
+// streams end and the servers stop accepting new RPCs and send GOAWAY while
+// in-flight RPCs finish. RPCs still
+// running after the drain timeout are cancelled, and drain returns once the
+// servers have stopped.
+func (p *GRPCServerModule) drain() {
+	p.health.Shutdown()
+
+	if p.shutdown.PreStopDelay > 0 {
+		log.Info("Waiting before draining GRPC server", "name", p.GetName(), "delay", p.shutdown.PreStopDelay)
+		time.Sleep(p.shutdown.PreStopDelay)
+	}
+	close(p.draining)
+
+	log.Info("Draining GRPC server", "name", p.GetName(), "inFlight", p.inFlight.load(), "timeout", p.shutdown.DrainTimeout)
+
+	forceCh := make(chan struct{})
+	var wg sync.WaitGroup
+	if p.inProcessServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.inProcessServer.GracefulStop()
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// The handler transport used by shared listeners can't send GOAWAY,
+		// so that server only stops once its RPCs are done. The shared
+		// listener drains its own connections.
+		if p.sharedListener != "" {
+			select {
+			case <-p.inFlight.idleCh():
+			case <-forceCh:
+			}
+			p.server.Stop()
+		} else {
+			p.server.GracefulStop()
+		}
+	}()
+
+	doneCh := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(doneCh)
+	}()
+
+	ticker := time.NewTicker(drainLogInterval)
+	defer ticker.Stop()
+	timeout := time.NewTimer(p.shutdown.DrainTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-doneCh:
+			log.Info("GRPC server drained", "name", p.GetName())
+			return
+		case <-ticker.C:
+			log.Info("Waiting for in-flight GRPC requests", "name", p.GetName(), "inFlight", p.inFlight.load())
+		case <-timeout.C:
+			log.Warn("GRPC drain timeout exceeded, forcing stop", "name", p.GetName(), "inFlight", p.inFlight.load())
+			// Stop closes all connections, which also ends the pending
+			// GracefulStop calls.
+			close(forceCh)
+			p.server.Stop()
+			if p.inProcessServer != nil {
+				p.inProcessServer.Stop()
+			}
+			<-doneCh
+			return
+		}
+	}
+}
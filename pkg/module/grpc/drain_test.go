@@ -0,0 +1,54 @@
+package grpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	grpcmod "github.com/dnikishov/microboiler/pkg/module/grpc"
+	"github.com/dnikishov/microboiler/pkg/module/grpc/grpctest"
+)
+
+func TestDrainEndsHealthWatch(t *testing.T) {
+	server := grpctest.NewServer(t, &grpcmod.Options{}, grpctest.Config{
+		"shutdown.preStopDelay": "100ms",
+		"shutdown.drainTimeout": "10s",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watch, err := healthpb.NewHealthClient(server.Conn).Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Watch() error = %s", err)
+	}
+	if resp, err := watch.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("first status = %v, %v, want SERVING", resp, err)
+	}
+
+	stopped := make(chan struct{})
+	start := time.Now()
+	go func() {
+		server.Stop()
+		close(stopped)
+	}()
+
+	if resp, err := watch.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("status after stop = %v, %v, want NOT_SERVING", resp, err)
+	}
+	if _, err := watch.Recv(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Watch ended with %v, want Unavailable", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Watch ended %s after stop, only when the drain timed out", elapsed)
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server is still draining with only a health watcher connected")
+	}
+}
@@ -0,0 +1,192 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/viper"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const internalErrorMessage = "internal error"
+
+type ErrorMappingConfig struct {
+	Enabled bool
+	// Domain is reported in the ErrorInfo details of mapped errors.
+	Domain string
+}
+
+func parseErrorMappingConfig(configPrefix string, name string) ErrorMappingConfig {
+	errorsPrefix := fmt.Sprintf("%s.errors", configPrefix)
+
+	enabledKey := fmt.Sprintf("%s.enabled", errorsPrefix)
+	enabled := true
+	if viper.IsSet(enabledKey) {
+		enabled = viper.GetBool(enabledKey)
+	}
+
+	domain := viper.GetString(fmt.Sprintf("%s.domain", errorsPrefix))
+	if domain == "" {
+		domain = name
+	}
+
+	return ErrorMappingConfig{Enabled: enabled, Domain: domain}
+}
+
+type ErrorMapping struct {
+	// Target is matched against returned errors with errors.Is.
+	Target error
+	Code   codes.Code
+	// Reason is reported in ErrorInfo details when set.
+	Reason string
+	// Message is returned to clients instead of the error, whose text may
+	// include queries or wrapped causes. Defaults to the text of Target.
+	Message string
+}
+
+// ErrorMapperFunc converts err to a status, reporting false if it doesn't
+// handle err.
+type ErrorMapperFunc func(err error) (*status.Status, bool)
+
+// ErrorRegistry maps errors returned by services to gRPC statuses. Functions
+// are tried before mappings, and later registrations take precedence over
+// earlier ones, so the defaults can be overridden.
+type ErrorRegistry struct {
+	mu       sync.RWMutex
+	mappings []ErrorMapping
+	funcs    []ErrorMapperFunc
+}
+
+// NewErrorRegistry returns a registry with mappings for context and GORM
+// errors. GORM errors such as ErrDuplicatedKey require TranslateError, which
+// GORMDatabaseModule enables.
+func NewErrorRegistry() *ErrorRegistry {
+	registry := &ErrorRegistry{}
+	registry.Register(context.Canceled, codes.Canceled, "CANCELED")
+	registry.Register(context.DeadlineExceeded, codes.DeadlineExceeded, "DEADLINE_EXCEEDED")
+	registry.Register(gorm.ErrRecordNotFound, codes.NotFound, "RECORD_NOT_FOUND")
+	registry.Register(gorm.ErrDuplicatedKey, codes.AlreadyExists, "DUPLICATED_KEY")
+	registry.Register(gorm.ErrForeignKeyViolated, codes.FailedPrecondition, "FOREIGN_KEY_VIOLATED")
+	registry.Register(gorm.ErrCheckConstraintViolated, codes.InvalidArgument, "CHECK_CONSTRAINT_VIOLATED")
+	return registry
+}
+
+func (r *ErrorRegistry) Register(target error, code codes.Code, reason string) {
+	r.RegisterMapping(ErrorMapping{Target: target, Code: code, Reason: reason})
+}
+
+func (r *ErrorRegistry) RegisterMapping(mapping ErrorMapping) {
+	if mapping.Message == "" {
+		mapping.Message = mapping.Target.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mappings = append(r.mappings, mapping)
+}
+
+func (r *ErrorRegistry) RegisterFunc(fn ErrorMapperFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.funcs = append(r.funcs, fn)
+}
+
+func (r *ErrorRegistry) lookup(err error) (*status.Status, *ErrorMapping) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := len(r.funcs) - 1; i >= 0; i-- {
+		if st, ok := r.funcs[i](err); ok {
+			return st, nil
+		}
+	}
+
+	for i := len(r.mappings) - 1; i >= 0; i-- {
+		if errors.Is(err, r.mappings[i].Target) {
+			return nil, &r.mappings[i]
+		}
+	}
+
+	return nil, nil
+}
+
+type errorMapper struct {
+	name     string
+	config   ErrorMappingConfig
+	registry *ErrorRegistry
+}
+
+// convert turns errors that aren't gRPC statuses into statuses using the
+// registry, falling back to Internal. Clients get the fixed message of the
+// mapping, or a generic one for Internal errors, while the full error is
+// logged so that implementation details don't reach clients.
+func (m *errorMapper) convert(ctx context.Context, method string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		st = m.statusFor(err)
+	}
+
+	if st.Code() != codes.Internal || st.Message() == internalErrorMessage {
+		if !ok {
+			log.Info("GRPC handler error mapped to status", "name", m.name, "method", method, "request_id", RequestIDFromContext(ctx), "code", st.Code(), "error", err)
+		}
+		return st.Err()
+	}
+
+	requestID := RequestIDFromContext(ctx)
+	log.Error("GRPC handler failed", "name", m.name, "method", method, "request_id", requestID, "error", err)
+
+	hidden := status.New(codes.Internal, internalErrorMessage)
+	if requestID != "" {
+		detailed, detailsErr := hidden.WithDetails(&errdetails.RequestInfo{RequestId: requestID})
+		if detailsErr == nil {
+			hidden = detailed
+		}
+	}
+	return hidden.Err()
+}
+
+func (m *errorMapper) statusFor(err error) *status.Status {
+	st, mapping := m.registry.lookup(err)
+	if st != nil {
+		return st
+	}
+	if mapping == nil {
+		return status.New(codes.Internal, err.Error())
+	}
+
+	st = status.New(mapping.Code, mapping.Message)
+	if mapping.Reason == "" {
+		return st
+	}
+	detailed, detailsErr := st.WithDetails(&errdetails.ErrorInfo{Reason: mapping.Reason, Domain: m.config.Domain})
+	if detailsErr != nil {
+		return st
+	}
+	return detailed
+}
+
+func (m *errorMapper) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		return resp, m.convert(ctx, info.FullMethod, err)
+	}
+}
+
+func (m *errorMapper) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, ss)
+		return m.convert(ss.Context(), info.FullMethod, err)
+	}
+}
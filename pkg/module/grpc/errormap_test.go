@@ -0,0 +1,55 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+func TestErrorMapperConvert(t *testing.T) {
+	errQuota := errors.New("quota exceeded")
+
+	registry := NewErrorRegistry()
+	registry.RegisterMapping(ErrorMapping{Target: errQuota, Code: codes.ResourceExhausted, Reason: "QUOTA", Message: "quota exceeded, try again tomorrow"})
+	mapper := &errorMapper{name: "test", config: ErrorMappingConfig{Enabled: true, Domain: "test.example"}, registry: registry}
+
+	tests := []struct {
+		name        string
+		err         error
+		wantCode    codes.Code
+		wantMessage string
+		wantReason  string
+	}{
+		{name: "no error", err: nil, wantCode: codes.OK},
+		{name: "status", err: status.Error(codes.NotFound, "user 42 not found"), wantCode: codes.NotFound, wantMessage: "user 42 not found"},
+		{name: "mapped error hides wrapped text", err: fmt.Errorf("SELECT * FROM users WHERE id = 42: %w", gorm.ErrRecordNotFound), wantCode: codes.NotFound, wantMessage: "record not found", wantReason: "RECORD_NOT_FOUND"},
+		{name: "custom message", err: fmt.Errorf("user 42: %w", errQuota), wantCode: codes.ResourceExhausted, wantMessage: "quota exceeded, try again tomorrow", wantReason: "QUOTA"},
+		{name: "unmapped error", err: errors.New("dial tcp 10.0.0.1:3306: connection refused"), wantCode: codes.Internal, wantMessage: internalErrorMessage},
+		{name: "internal status", err: status.Error(codes.Internal, "nil pointer"), wantCode: codes.Internal, wantMessage: internalErrorMessage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := status.Convert(mapper.convert(context.Background(), "/test.Service/Get", tt.err))
+			if st.Code() != tt.wantCode || st.Message() != tt.wantMessage {
+				t.Fatalf("convert() = %s (%q), want %s (%q)", st.Code(), st.Message(), tt.wantCode, tt.wantMessage)
+			}
+
+			var reason string
+			for _, detail := range st.Details() {
+				if info, ok := detail.(*errdetails.ErrorInfo); ok {
+					reason = info.Reason
+				}
+			}
+			if reason != tt.wantReason {
+				t.Errorf("ErrorInfo reason = %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
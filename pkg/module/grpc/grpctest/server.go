@@ -0,0 +1,129 @@
+// Package grpctest runs services registered for GRPCServerModule on in-memory
+// connections, so that service tests don't need a network listener.
+package grpctest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+
+	"github.com/dnikishov/microboiler/pkg/module"
+	grpcmod "github.com/dnikishov/microboiler/pkg/module/grpc"
+)
+
+const readyTimeout = 5 * time.Second
+
+var serverCount atomic.Int64
+
+// Config holds module configuration keys relative to grpc-<name>, e.g.
+// "auth.enabled" or "interceptors.accessLog".
+type Config map[string]interface{}
+
+type Server struct {
+	Module *grpcmod.GRPCServerModule
+	Conn   *grpc.ClientConn
+
+	stopOnce sync.Once
+	stop     func()
+}
+
+// Stop shuts the server down the way the application does, draining it first,
+// and returns once it has stopped. It is called when the test finishes, so
+// tests only need it to observe the shutdown itself.
+func (s *Server) Stop() {
+	s.stopOnce.Do(s.stop)
+}
+
+// NewServer configures and starts a GRPCServerModule for options with the
+// same interceptors as in production, serving only its in-process listener,
+// and returns it with a ready client connection. Everything is stopped, and
+// the configuration and shared collectors the module registered are removed,
+// when the test finishes.
+//
+// Configuration goes through the global viper instance, so tests using
+// NewServer must not run in parallel.
+func NewServer(tb testing.TB, options *grpcmod.Options, config Config, dialOptions ...grpc.DialOption) *Server {
+	tb.Helper()
+
+	name := fmt.Sprintf("grpctest-%d", serverCount.Add(1))
+	configPrefix := fmt.Sprintf("grpc-%s", name)
+
+	for key, value := range config {
+		fullKey := fmt.Sprintf("%s.%s", configPrefix, key)
+		previous, wasSet := viper.Get(fullKey), viper.IsSet(fullKey)
+		viper.Set(fullKey, value)
+		tb.Cleanup(func() {
+			if wasSet {
+				viper.Set(fullKey, previous)
+			} else {
+				viper.Set(fullKey, nil)
+			}
+		})
+	}
+
+	registered := make(map[string]bool)
+	for _, collector := range module.SharedCollectors() {
+		registered[collector.Name] = true
+	}
+
+	server := grpcmod.NewGRPCServerModule(name, options)
+	server.ServeInProcessOnly()
+	err := server.Configure()
+
+	tb.Cleanup(func() {
+		for _, collector := range module.SharedCollectors() {
+			if !registered[collector.Name] {
+				module.UnregisterCollector(collector.Name)
+			}
+		}
+	})
+
+	if err != nil {
+		tb.Fatalf("failed to configure GRPC server: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = server.Init(ctx)
+	if err != nil {
+		cancel()
+		tb.Fatalf("failed to initialize GRPC server: %s", err)
+	}
+
+	mainDone := make(chan struct{})
+	go func() {
+		defer close(mainDone)
+		err := server.Main(ctx)
+		if err != nil {
+			tb.Errorf("GRPC server failed: %s", err)
+		}
+	}()
+
+	stop := func() {
+		cancel()
+		server.Cleanup(ctx)
+		<-mainDone
+	}
+
+	dialCtx, dialCancel := context.WithTimeout(ctx, readyTimeout)
+	defer dialCancel()
+
+	conn, err := server.DialInProcess(dialCtx, append([]grpc.DialOption{grpc.WithBlock()}, dialOptions...)...)
+	if err != nil {
+		stop()
+		tb.Fatalf("failed to connect to GRPC server: %s", err)
+	}
+
+	s := &Server{Module: server, Conn: conn, stop: stop}
+	tb.Cleanup(func() {
+		s.Stop()
+		conn.Close()
+	})
+
+	return s
+}
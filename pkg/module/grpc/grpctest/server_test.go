@@ -0,0 +1,54 @@
+package grpctest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/viper"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/dnikishov/microboiler/pkg/module"
+	grpcmod "github.com/dnikishov/microboiler/pkg/module/grpc"
+)
+
+func sharedCollectorNames() map[string]bool {
+	names := make(map[string]bool)
+	for _, collector := range module.SharedCollectors() {
+		names[collector.Name] = true
+	}
+	return names
+}
+
+func TestNewServer(t *testing.T) {
+	before := sharedCollectorNames()
+	var configPrefix string
+
+	t.Run("serves", func(t *testing.T) {
+		server := NewServer(t, &grpcmod.Options{}, Config{"exportMetrics": true})
+		configPrefix = fmt.Sprintf("grpc-%s", server.Module.GetName())
+
+		if !viper.GetBool(configPrefix + ".exportMetrics") {
+			t.Fatal("configuration was not applied")
+		}
+		if !sharedCollectorNames()[configPrefix] {
+			t.Fatal("server metrics were not registered")
+		}
+
+		response, err := healthpb.NewHealthClient(server.Conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatalf("health check failed: %s", err)
+		}
+		if response.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("health status = %s, want SERVING", response.Status)
+		}
+	})
+
+	if viper.IsSet(configPrefix + ".exportMetrics") {
+		t.Error("configuration was not removed after the test")
+	}
+	after := sharedCollectorNames()
+	if len(after) != len(before) {
+		t.Errorf("shared collectors after the test = %v, want %v", after, before)
+	}
+}
@@ -0,0 +1,125 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/charmbracelet/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// HealthReporter lets a registered service update its own health status,
+// e.g. when a dependency becomes unavailable.
+type HealthReporter interface {
+	SetServing(serving bool)
+}
+
+// WithHealthReporter is implemented by services that want to report their
+// own health. SetHealthReporter is called once during Init.
+type WithHealthReporter interface {
+	SetHealthReporter(reporter HealthReporter)
+}
+
+type serviceHealthReporter struct {
+	module  *GRPCServerModule
+	service string
+}
+
+func (r *serviceHealthReporter) SetServing(serving bool) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	r.module.SetServingStatus(r.service, status)
+}
+
+// drainingHealthServer ends Watch streams once the server starts draining.
+// They stay open for the life of a connection otherwise, and graceful stop
+// would wait for them until the drain timeout.
+type drainingHealthServer struct {
+	*health.Server
+	draining <-chan struct{}
+}
+
+type healthWatchStream struct {
+	healthpb.Health_WatchServer
+	ctx context.Context
+}
+
+func (s *healthWatchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *drainingHealthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-s.draining:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := s.Server.Watch(req, &healthWatchStream{Health_WatchServer: stream, ctx: ctx})
+	select {
+	case <-s.draining:
+		return status.Error(codes.Unavailable, "server is shutting down")
+	default:
+		return err
+	}
+}
+
+func (p *GRPCServerModule) registerHealth() {
+	p.health = health.NewServer()
+	p.healthReported = make(map[string]bool)
+	p.draining = make(chan struct{})
+
+	healthServer := &drainingHealthServer{Server: p.health, draining: p.draining}
+	healthpb.RegisterHealthServer(p.server, healthServer)
+	if p.inProcessServer != nil {
+		healthpb.RegisterHealthServer(p.inProcessServer, healthServer)
+	}
+
+	p.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	for _, entry := range p.options.ServiceRegistry {
+		p.health.SetServingStatus(entry.ServiceDesc.ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+
+		withReporter, ok := entry.Service.(WithHealthReporter)
+		if ok {
+			withReporter.SetHealthReporter(&serviceHealthReporter{module: p, service: entry.ServiceDesc.ServiceName})
+		}
+	}
+}
+
+// markServing reports SERVING for the server and every service whose status
+// hasn't been set through SetServingStatus, which takes precedence.
+func (p *GRPCServerModule) markServing() {
+	p.healthMu.Lock()
+	defer p.healthMu.Unlock()
+
+	services := []string{""}
+	for _, entry := range p.options.ServiceRegistry {
+		services = append(services, entry.ServiceDesc.ServiceName)
+	}
+
+	for _, service := range services {
+		if !p.healthReported[service] {
+			p.health.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+		}
+	}
+}
+
+// SetServingStatus updates the health status reported for service. An empty
+// service name refers to the server as a whole.
+func (p *GRPCServerModule) SetServingStatus(service string, status healthpb.HealthCheckResponse_ServingStatus) {
+	log.Info("Updating GRPC health status", "name", p.GetName(), "service", service, "status", status)
+
+	p.healthMu.Lock()
+	defer p.healthMu.Unlock()
+	p.healthReported[service] = true
+	p.health.SetServingStatus(service, status)
+}
@@ -0,0 +1,221 @@
+package grpc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	// ForwardedForHeader carries the address of the client an in-process
+	// caller (e.g. the HTTP gateway) is acting for.
+	ForwardedForHeader = "x-forwarded-for"
+	// ForwardedIdentityHeader carries the verified certificate identity of
+	// that client.
+	ForwardedIdentityHeader = "x-forwarded-client-identity"
+
+	// forwardingTokenHeader proves that the forwarded headers were set by
+	// ForwardCaller rather than by whoever supplied the rest of the metadata.
+	forwardingTokenHeader = "x-forwarded-token"
+)
+
+// pipeListener hands out in-memory connections created by DialContext.
+type pipeListener struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+func (l *pipeListener) DialContext(ctx context.Context) (net.Conn, error) {
+	server, client := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		server.Close()
+		client.Close()
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		server.Close()
+		client.Close()
+		return nil, ctx.Err()
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "in-process" }
+
+// EnableInProcess makes the module serve its services to other modules in the
+// same process (e.g. an HTTP gateway) through DialInProcess. It must be called
+// before Init, typically from the caller's Configure.
+func (p *GRPCServerModule) EnableInProcess() {
+	if p.inProcessListener == nil {
+		p.inProcessListener = newPipeListener()
+		p.forwardingToken = newForwardingToken()
+	}
+}
+
+func newForwardingToken() string {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		panic(fmt.Sprintf("failed to generate in-process forwarding token: %s", err))
+	}
+	return hex.EncodeToString(token)
+}
+
+// ServeInProcessOnly makes the module serve only in-process connections and
+// ignore its network listener configuration, e.g. in tests. It must be called
+// before Configure.
+func (p *GRPCServerModule) ServeInProcessOnly() {
+	p.EnableInProcess()
+	p.inProcessOnly = true
+}
+
+// The in-process server shares services, interceptors and health state with
+// the main server but listens on in-memory connections without transport
+// credentials. Callers report the client they act for with ForwardCaller, which
+// replaces the peer seen by auth and rate limiting. Forwarded headers without
+// the module's token are dropped, so other DialInProcess callers can't claim
+// an address or identity.
+func (p *GRPCServerModule) initInProcess(serverOptions []grpc.ServerOption) {
+	if p.inProcessListener == nil {
+		return
+	}
+
+	options := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(forwardedPeerUnaryInterceptor(p.forwardingToken)),
+		grpc.ChainStreamInterceptor(forwardedPeerStreamInterceptor(p.forwardingToken)),
+	}
+	p.inProcessServer = grpc.NewServer(append(options, serverOptions...)...)
+
+	for _, entry := range p.options.ServiceRegistry {
+		p.inProcessServer.RegisterService(&entry.ServiceDesc, entry.Service)
+	}
+}
+
+// forwardedIdentityKey holds the client identity reported by an in-process
+// caller.
+type forwardedIdentityKey struct{}
+
+func forwardedIdentityFromContext(ctx context.Context) (string, bool) {
+	identity, ok := ctx.Value(forwardedIdentityKey{}).(string)
+	return identity, ok && identity != ""
+}
+
+// withForwardedPeer applies the forwarded headers if they carry token and
+// removes them from the metadata seen by handlers.
+func withForwardedPeer(ctx context.Context, token string) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+
+	trusted := subtle.ConstantTimeCompare([]byte(incomingHeader(ctx, forwardingTokenHeader)), []byte(token)) == 1
+	if trusted {
+		if address := incomingHeader(ctx, ForwardedForHeader); address != "" {
+			ctx = peer.NewContext(ctx, &peer.Peer{Addr: forwardedAddr(address)})
+		}
+		if identity := incomingHeader(ctx, ForwardedIdentityHeader); identity != "" {
+			ctx = context.WithValue(ctx, forwardedIdentityKey{}, identity)
+		}
+	}
+
+	md = md.Copy()
+	md.Delete(ForwardedForHeader)
+	md.Delete(ForwardedIdentityHeader)
+	md.Delete(forwardingTokenHeader)
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+type forwardedAddr string
+
+func (a forwardedAddr) Network() string { return "tcp" }
+func (a forwardedAddr) String() string  { return string(a) }
+
+func forwardedPeerUnaryInterceptor(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(withForwardedPeer(ctx, token), req)
+	}
+}
+
+func forwardedPeerStreamInterceptor(token string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, wrapServerStream(ss, withForwardedPeer(ss.Context(), token)))
+	}
+}
+
+// ForwardCaller sets the forwarded headers in md for a call to the in-process
+// server, replacing any values the client may have supplied itself. identity
+// must come from a verified client certificate. EnableInProcess must have
+// been called.
+func (p *GRPCServerModule) ForwardCaller(md metadata.MD, address string, identity string) {
+	md.Delete(ForwardedForHeader)
+	md.Delete(ForwardedIdentityHeader)
+	md.Delete(forwardingTokenHeader)
+	if address == "" && identity == "" {
+		return
+	}
+
+	md.Set(forwardingTokenHeader, p.forwardingToken)
+	if address != "" {
+		md.Set(ForwardedForHeader, address)
+	}
+	if identity != "" {
+		md.Set(ForwardedIdentityHeader, identity)
+	}
+}
+
+// DialInProcess returns a client connection to the module's in-process server.
+// EnableInProcess must have been called; connections become usable once Main
+// runs.
+func (p *GRPCServerModule) DialInProcess(ctx context.Context, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if p.inProcessListener == nil {
+		return nil, fmt.Errorf("in-process connections are not enabled for GRPC server %s", p.GetName())
+	}
+
+	dialOptions := []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return p.inProcessListener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}
+	dialOptions = append(dialOptions, opts...)
+
+	return grpc.DialContext(ctx, "passthrough:///"+p.GetName(), dialOptions...)
+}
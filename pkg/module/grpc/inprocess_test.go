@@ -0,0 +1,102 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestConfigureListenerRequirement(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(p *GRPCServerModule)
+		wantErr string
+	}{
+		{name: "network server", setup: func(p *GRPCServerModule) {}, wantErr: "listenAddress is not set"},
+		{name: "in-process enabled first", setup: func(p *GRPCServerModule) { p.EnableInProcess() }, wantErr: "listenAddress is not set"},
+		{name: "in-process only", setup: func(p *GRPCServerModule) { p.ServeInProcessOnly() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewGRPCServerModule("inprocess-test", &Options{})
+			tt.setup(p)
+
+			err := p.Configure()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Configure() error = %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Configure() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithForwardedPeer(t *testing.T) {
+	server := NewGRPCServerModule("forwarder", &Options{})
+	server.EnableInProcess()
+
+	tests := []struct {
+		name         string
+		md           func() metadata.MD
+		wantAddress  string
+		wantIdentity string
+	}{
+		{
+			name: "forwarded by the module",
+			md: func() metadata.MD {
+				md := metadata.Pairs(ForwardedIdentityHeader, "spoofed")
+				server.ForwardCaller(md, "10.0.0.1", "spiffe://cluster/client")
+				return md
+			},
+			wantAddress:  "10.0.0.1",
+			wantIdentity: "spiffe://cluster/client",
+		},
+		{
+			name: "headers without token",
+			md: func() metadata.MD {
+				return metadata.Pairs(ForwardedForHeader, "10.0.0.1", ForwardedIdentityHeader, "spiffe://cluster/client")
+			},
+		},
+		{
+			name: "token of another module",
+			md: func() metadata.MD {
+				other := NewGRPCServerModule("other", &Options{})
+				other.EnableInProcess()
+				md := metadata.MD{}
+				other.ForwardCaller(md, "10.0.0.1", "spiffe://cluster/client")
+				return md
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md())
+			ctx = withForwardedPeer(ctx, server.forwardingToken)
+
+			address := ""
+			if pr, ok := peer.FromContext(ctx); ok {
+				address = pr.Addr.String()
+			}
+			identity, _ := forwardedIdentityFromContext(ctx)
+			if address != tt.wantAddress || identity != tt.wantIdentity {
+				t.Errorf("forwarded peer = %q/%q, want %q/%q", address, identity, tt.wantAddress, tt.wantIdentity)
+			}
+
+			md, _ := metadata.FromIncomingContext(ctx)
+			for _, key := range []string{ForwardedForHeader, ForwardedIdentityHeader, forwardingTokenHeader} {
+				if values := md.Get(key); len(values) > 0 {
+					t.Errorf("%s = %v was passed to the handler", key, values)
+				}
+			}
+		})
+	}
+}
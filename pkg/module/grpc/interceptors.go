@@ -0,0 +1,174 @@
+package grpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const RequestIDHeader = "x-request-id"
+
+type requestIDKey struct{}
+
+type InterceptorsConfig struct {
+	Recovery  bool
+	AccessLog bool
+	RequestID bool
+}
+
+func parseInterceptorsConfig(configPrefix string) InterceptorsConfig {
+	interceptorsPrefix := fmt.Sprintf("%s.interceptors", configPrefix)
+
+	recoveryKey := fmt.Sprintf("%s.recovery", interceptorsPrefix)
+	recovery := true
+	if viper.IsSet(recoveryKey) {
+		recovery = viper.GetBool(recoveryKey)
+	}
+
+	return InterceptorsConfig{
+		Recovery:  recovery,
+		AccessLog: viper.GetBool(fmt.Sprintf("%s.accessLog", interceptorsPrefix)),
+		RequestID: viper.GetBool(fmt.Sprintf("%s.requestId", interceptorsPrefix)),
+	}
+}
+
+// RequestIDFromContext returns the request ID assigned by the request ID
+// interceptor, or an empty string if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+type wrappedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *wrappedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func wrapServerStream(ss grpc.ServerStream, ctx context.Context) grpc.ServerStream {
+	return &wrappedServerStream{ServerStream: ss, ctx: ctx}
+}
+
+func newRequestID() string {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+func requestIDContext(ctx context.Context) context.Context {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(RequestIDHeader); len(values) > 0 && values[0] != "" {
+			id = values[0]
+		}
+	}
+	if id == "" {
+		id = newRequestID()
+	}
+
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return metadata.AppendToOutgoingContext(ctx, RequestIDHeader, id)
+}
+
+func requestIDUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx = requestIDContext(ctx)
+		grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, RequestIDFromContext(ctx)))
+		return handler(ctx, req)
+	}
+}
+
+func requestIDStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := requestIDContext(ss.Context())
+		ss.SetHeader(metadata.Pairs(RequestIDHeader, RequestIDFromContext(ctx)))
+		return handler(srv, wrapServerStream(ss, ctx))
+	}
+}
+
+func (p *GRPCServerModule) logAccess(ctx context.Context, method string, start time.Time, err error) {
+	peerAddress := ""
+	if pr, ok := peer.FromContext(ctx); ok {
+		peerAddress = pr.Addr.String()
+	}
+
+	code := status.Code(err)
+	keyvals := []interface{}{
+		"name", p.GetName(),
+		"method", method,
+		"peer", peerAddress,
+		"code", code.String(),
+		"duration", time.Since(start),
+	}
+	if id := RequestIDFromContext(ctx); id != "" {
+		keyvals = append(keyvals, "request_id", id)
+	}
+
+	if code == codes.OK {
+		log.Info("GRPC request", keyvals...)
+	} else {
+		log.Warn("GRPC request", append(keyvals, "error", err)...)
+	}
+}
+
+func (p *GRPCServerModule) accessLogUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		p.logAccess(ctx, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+func (p *GRPCServerModule) accessLogStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		p.logAccess(ss.Context(), info.FullMethod, start, err)
+		return err
+	}
+}
+
+func (p *GRPCServerModule) recoverPanic(ctx context.Context, method string, r interface{}) error {
+	log.Error("Recovered from panic in GRPC handler", "name", p.GetName(), "method", method, "request_id", RequestIDFromContext(ctx), "panic", r, "stack", string(debug.Stack()))
+	return status.Error(codes.Internal, "internal error")
+}
+
+func (p *GRPCServerModule) recoveryUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = p.recoverPanic(ctx, info.FullMethod, r)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+func (p *GRPCServerModule) recoveryStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = p.recoverPanic(ss.Context(), info.FullMethod, r)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
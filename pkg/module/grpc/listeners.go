@@ -0,0 +1,161 @@
+package grpc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/viper"
+)
+
+const unixScheme = "unix://"
+
+type ListenerConfig struct {
+	Address    string
+	SocketMode string
+}
+
+func (c ListenerConfig) isUnix() bool {
+	return strings.HasPrefix(c.Address, unixScheme)
+}
+
+func (c ListenerConfig) socketPath() string {
+	return strings.TrimPrefix(c.Address, unixScheme)
+}
+
+func parseListenerConfigs(configPrefix string, allowEmpty bool) ([]ListenerConfig, error) {
+	var listeners []ListenerConfig
+
+	listenAddress := viper.GetString(fmt.Sprintf("%s.listenAddress", configPrefix))
+	if listenAddress != "" {
+		listeners = append(listeners, ListenerConfig{
+			Address:    listenAddress,
+			SocketMode: viper.GetString(fmt.Sprintf("%s.socketMode", configPrefix)),
+		})
+	}
+
+	var extra []ListenerConfig
+	err := viper.UnmarshalKey(fmt.Sprintf("%s.listeners", configPrefix), &extra)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %s.listeners: %s", configPrefix, err)
+	}
+	listeners = append(listeners, extra...)
+
+	if len(listeners) == 0 && !allowEmpty {
+		return nil, fmt.Errorf("invalid configuration: %s.listenAddress is not set", configPrefix)
+	}
+
+	for _, listener := range listeners {
+		if listener.Address == "" {
+			return nil, fmt.Errorf("invalid configuration: %s.listeners entries need an address", configPrefix)
+		}
+		if listener.isUnix() && listener.socketPath() == "" {
+			return nil, fmt.Errorf("invalid configuration: %s has an empty socket path", listener.Address)
+		}
+		if listener.SocketMode != "" {
+			if !listener.isUnix() {
+				return nil, fmt.Errorf("invalid configuration: socketMode is only supported for unix sockets, got %s", listener.Address)
+			}
+			_, err := strconv.ParseUint(listener.SocketMode, 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid configuration: invalid socketMode %s for %s", listener.SocketMode, listener.Address)
+			}
+		}
+	}
+
+	return listeners, nil
+}
+
+// removeStaleSocket deletes a socket file left behind by a previous process,
+// refusing to touch it if something is still accepting connections on it.
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use by another process", path)
+	}
+
+	log.Info("Removing stale GRPC socket", "path", path)
+	return os.Remove(path)
+}
+
+func listen(config ListenerConfig) (net.Listener, error) {
+	if !config.isUnix() {
+		return net.Listen("tcp", config.Address)
+	}
+
+	path := config.socketPath()
+	err := removeStaleSocket(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.SocketMode == "" {
+		return net.Listen("unix", path)
+	}
+
+	mode, _ := strconv.ParseUint(config.SocketMode, 8, 32)
+	return listenUnixWithMode(path, os.FileMode(mode))
+}
+
+// unixSocketListener serves a socket that was created under a different name
+// and moved into place, so it reports and removes the final path.
+type unixSocketListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixSocketListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+func (l *unixSocketListener) Close() error {
+	err := l.UnixListener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+// listenUnixWithMode creates the socket in a private directory next to path,
+// applies mode and only then moves it into place, so the socket is never
+// reachable with the default permissions.
+func listenUnixWithMode(path string, mode os.FileMode) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".grpc-socket-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmpPath := filepath.Join(dir, "socket")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmpPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	listener.SetUnlinkOnClose(false)
+
+	err = os.Chmod(tmpPath, mode)
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return &unixSocketListener{UnixListener: listener, path: path}, nil
+}
@@ -0,0 +1,286 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	limitReasonLoadShedding = "load_shedding"
+
+	loadSheddingAIMD     = "aimd"
+	loadSheddingGradient = "gradient"
+
+	// Weight of the newest sample in the gradient algorithm's long-term
+	// latency average.
+	gradientLongTermWeight = 0.01
+)
+
+type Priority string
+
+const (
+	PriorityCritical Priority = "critical"
+	PriorityHigh     Priority = "high"
+	PriorityNormal   Priority = "normal"
+	PriorityLow      Priority = "low"
+)
+
+// Share of the current concurrency limit each priority class may use, so
+// that lower classes are shed first as the server approaches the limit.
+var priorityShares = map[Priority]float64{
+	PriorityCritical: 1.0,
+	PriorityHigh:     0.9,
+	PriorityNormal:   0.8,
+	PriorityLow:      0.5,
+}
+
+var defaultPriorities = []MethodPriority{
+	{Method: "/grpc.health.v1.Health/*", Priority: PriorityCritical},
+}
+
+type MethodPriority struct {
+	Method   string
+	Priority Priority
+}
+
+type LoadSheddingConfig struct {
+	Enabled          bool
+	Algorithm        string
+	InitialLimit     int
+	MinLimit         int
+	MaxLimit         int
+	LatencyThreshold time.Duration
+	BackoffRatio     float64
+	Smoothing        float64
+	Priorities       []MethodPriority
+}
+
+func parseLoadSheddingConfig(configPrefix string) (*LoadSheddingConfig, error) {
+	sheddingPrefix := fmt.Sprintf("%s.loadShedding", configPrefix)
+
+	config := &LoadSheddingConfig{
+		Enabled:          viper.GetBool(fmt.Sprintf("%s.enabled", sheddingPrefix)),
+		Algorithm:        strings.ToLower(viper.GetString(fmt.Sprintf("%s.algorithm", sheddingPrefix))),
+		InitialLimit:     viper.GetInt(fmt.Sprintf("%s.initialLimit", sheddingPrefix)),
+		MinLimit:         viper.GetInt(fmt.Sprintf("%s.minLimit", sheddingPrefix)),
+		MaxLimit:         viper.GetInt(fmt.Sprintf("%s.maxLimit", sheddingPrefix)),
+		LatencyThreshold: viper.GetDuration(fmt.Sprintf("%s.latencyThreshold", sheddingPrefix)),
+		BackoffRatio:     viper.GetFloat64(fmt.Sprintf("%s.backoffRatio", sheddingPrefix)),
+		Smoothing:        viper.GetFloat64(fmt.Sprintf("%s.smoothing", sheddingPrefix)),
+	}
+
+	if !config.Enabled {
+		return config, nil
+	}
+
+	if config.Algorithm == "" {
+		config.Algorithm = loadSheddingAIMD
+	}
+	if config.Algorithm != loadSheddingAIMD && config.Algorithm != loadSheddingGradient {
+		return nil, fmt.Errorf("invalid configuration: %s.algorithm must be %s or %s, got %s", sheddingPrefix, loadSheddingAIMD, loadSheddingGradient, config.Algorithm)
+	}
+
+	if config.MinLimit == 0 {
+		config.MinLimit = 10
+	}
+	if config.MaxLimit == 0 {
+		config.MaxLimit = 1000
+	}
+	if config.InitialLimit == 0 {
+		config.InitialLimit = 100
+	}
+	if config.MinLimit < 1 || config.MaxLimit < config.MinLimit {
+		return nil, fmt.Errorf("invalid configuration: %s.minLimit must be at least 1 and not greater than maxLimit", sheddingPrefix)
+	}
+	if config.InitialLimit < config.MinLimit || config.InitialLimit > config.MaxLimit {
+		return nil, fmt.Errorf("invalid configuration: %s.initialLimit must be between minLimit and maxLimit", sheddingPrefix)
+	}
+
+	if config.LatencyThreshold == 0 {
+		config.LatencyThreshold = time.Second
+	}
+	if config.BackoffRatio == 0 {
+		config.BackoffRatio = 0.9
+	}
+	if config.BackoffRatio < 0.5 || config.BackoffRatio >= 1 {
+		return nil, fmt.Errorf("invalid configuration: %s.backoffRatio must be in [0.5, 1)", sheddingPrefix)
+	}
+	if config.Smoothing == 0 {
+		config.Smoothing = 0.2
+	}
+	if config.Smoothing < 0 || config.Smoothing > 1 {
+		return nil, fmt.Errorf("invalid configuration: %s.smoothing must be in (0, 1]", sheddingPrefix)
+	}
+
+	var priorities []MethodPriority
+	err := viper.UnmarshalKey(fmt.Sprintf("%s.priorities", sheddingPrefix), &priorities)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %s.priorities: %s", sheddingPrefix, err)
+	}
+	for i := range priorities {
+		priorities[i].Priority = Priority(strings.ToLower(string(priorities[i].Priority)))
+		if priorities[i].Method == "" {
+			return nil, fmt.Errorf("invalid configuration: %s.priorities entries need a method", sheddingPrefix)
+		}
+		if _, ok := priorityShares[priorities[i].Priority]; !ok {
+			return nil, fmt.Errorf("invalid configuration: unknown priority %s for %s", priorities[i].Priority, priorities[i].Method)
+		}
+	}
+	// Configured priorities come first so they can override the defaults.
+	config.Priorities = append(priorities, defaultPriorities...)
+
+	return config, nil
+}
+
+func (c *LoadSheddingConfig) priorityFor(method string) Priority {
+	for _, rule := range c.Priorities {
+		if matchMethod(rule.Method, method) {
+			return rule.Priority
+		}
+	}
+	return PriorityNormal
+}
+
+// loadShedder is an adaptive concurrency limiter. The limit grows while
+// requests complete quickly and shrinks when latency rises, and requests
+// beyond the share of the limit allowed for their priority are rejected.
+type loadShedder struct {
+	config  *LoadSheddingConfig
+	metrics *ModuleMetrics
+
+	mu          sync.Mutex
+	limit       float64
+	inFlight    int
+	longTermRTT float64
+}
+
+func newLoadShedder(config *LoadSheddingConfig, metrics *ModuleMetrics) *loadShedder {
+	shedder := &loadShedder{config: config, metrics: metrics, limit: float64(config.InitialLimit)}
+	metrics.concurrencyLimit.Set(shedder.limit)
+	return shedder
+}
+
+// admits reports whether a request to method fits within the share of the
+// limit allowed for its priority. s.mu must be held.
+func (s *loadShedder) admits(method string) bool {
+	share := priorityShares[s.config.priorityFor(method)]
+	return float64(s.inFlight) < math.Max(1, math.Floor(s.limit*share))
+}
+
+func (s *loadShedder) acquire(method string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.admits(method) {
+		return false
+	}
+	s.inFlight++
+	return true
+}
+
+func (s *loadShedder) admitStream(method string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.admits(method)
+}
+
+func (s *loadShedder) release(rtt time.Duration, overloaded bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inFlight := s.inFlight
+	s.inFlight--
+
+	switch s.config.Algorithm {
+	case loadSheddingGradient:
+		s.updateGradient(rtt, overloaded, inFlight)
+	default:
+		s.updateAIMD(rtt, overloaded, inFlight)
+	}
+
+	s.limit = math.Min(math.Max(s.limit, float64(s.config.MinLimit)), float64(s.config.MaxLimit))
+	s.metrics.concurrencyLimit.Set(math.Floor(s.limit))
+}
+
+// updateAIMD adds one to the limit per fully utilised window of successful
+// requests and multiplies it by the backoff ratio on slow or failed ones.
+func (s *loadShedder) updateAIMD(rtt time.Duration, overloaded bool, inFlight int) {
+	if overloaded || rtt > s.config.LatencyThreshold {
+		s.limit *= s.config.BackoffRatio
+		return
+	}
+	if float64(inFlight)*2 >= s.limit {
+		s.limit += 1 / s.limit
+	}
+}
+
+// updateGradient scales the limit by the ratio of the long-term average
+// latency to the latest sample, leaving headroom of sqrt(limit) for queueing.
+// The limit is left alone while less than half of it is in use.
+func (s *loadShedder) updateGradient(rtt time.Duration, overloaded bool, inFlight int) {
+	sample := float64(rtt)
+	if overloaded {
+		sample = math.Max(sample, float64(s.config.LatencyThreshold))
+	}
+
+	if s.longTermRTT == 0 {
+		s.longTermRTT = sample
+	} else {
+		s.longTermRTT = s.longTermRTT*(1-gradientLongTermWeight) + sample*gradientLongTermWeight
+	}
+	if sample <= 0 || (!overloaded && float64(inFlight)*2 < s.limit) {
+		return
+	}
+
+	gradient := math.Min(math.Max(s.longTermRTT/sample, 0.5), 1.0)
+	newLimit := s.limit*gradient + math.Sqrt(s.limit)
+	s.limit = s.limit*(1-s.config.Smoothing) + newLimit*s.config.Smoothing
+}
+
+// isOverloadSignal reports whether err indicates the request failed because
+// the server is overloaded rather than because of the request itself. Only a
+// server-imposed deadline running out counts; errors such as rate limiting or
+// a client's own deadline say nothing about the server's capacity.
+func isOverloadSignal(ctx context.Context, err error) bool {
+	return status.Code(err) == codes.DeadlineExceeded && hasServerDeadline(ctx)
+}
+
+func (s *loadShedder) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		if !s.acquire(info.FullMethod) {
+			s.metrics.rejections.WithLabelValues(info.FullMethod, limitReasonLoadShedding).Inc()
+			return nil, status.Errorf(codes.Unavailable, "server is overloaded, try again later")
+		}
+
+		// The slot is released even if the handler panics, so that the
+		// recovery interceptor further out doesn't leak it.
+		start := time.Now()
+		defer func() {
+			s.release(time.Since(start), isOverloadSignal(ctx, err))
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+// Streams are only checked when they are set up: they are long-lived, so
+// holding a slot for their whole life would starve unary RPCs, and their
+// duration says nothing about server load.
+func (s *loadShedder) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !s.admitStream(info.FullMethod) {
+			s.metrics.rejections.WithLabelValues(info.FullMethod, limitReasonLoadShedding).Inc()
+			return status.Errorf(codes.Unavailable, "server is overloaded, try again later")
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,154 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsOverloadSignal(t *testing.T) {
+	enforcer := &deadlineEnforcer{config: &DeadlineConfig{}, metrics: newModuleMetrics("test")}
+
+	serverDeadline, cancel, _ := enforcer.withDeadline(context.Background(), time.Second)
+	defer cancel()
+
+	clientDeadline, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// The enforcer ran, but the client's own deadline was earlier.
+	earlierClientDeadline, cancel, _ := enforcer.withDeadline(clientDeadline, time.Hour)
+	defer cancel()
+
+	deadlineExceeded := status.Error(codes.DeadlineExceeded, "deadline exceeded")
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{name: "success", ctx: serverDeadline, err: nil, want: false},
+		{name: "server deadline exceeded", ctx: serverDeadline, err: deadlineExceeded, want: true},
+		{name: "client deadline exceeded", ctx: clientDeadline, err: deadlineExceeded, want: false},
+		{name: "earlier client deadline exceeded", ctx: earlierClientDeadline, err: deadlineExceeded, want: false},
+		{name: "no deadline", ctx: context.Background(), err: deadlineExceeded, want: false},
+		{name: "rate limited", ctx: serverDeadline, err: resourceExhausted("rate limit exceeded", time.Second), want: false},
+		{name: "shed downstream", ctx: serverDeadline, err: status.Error(codes.Unavailable, "server is overloaded"), want: false},
+		{name: "application error", ctx: serverDeadline, err: status.Error(codes.Internal, "failed"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isOverloadSignal(tt.ctx, tt.err); got != tt.want {
+				t.Errorf("isOverloadSignal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestShedder(algorithm string, initialLimit int) *loadShedder {
+	return newLoadShedder(&LoadSheddingConfig{
+		Enabled:          true,
+		Algorithm:        algorithm,
+		InitialLimit:     initialLimit,
+		MinLimit:         1,
+		MaxLimit:         100,
+		LatencyThreshold: time.Second,
+		BackoffRatio:     0.5,
+		Smoothing:        1,
+		Priorities:       defaultPriorities,
+	}, newModuleMetrics("test"))
+}
+
+func TestLoadShedderPriorities(t *testing.T) {
+	shedder := newTestShedder(loadSheddingAIMD, 10)
+
+	// Normal priority may use 80% of the limit.
+	for i := 0; i < 8; i++ {
+		if !shedder.acquire("/test.Service/Method") {
+			t.Fatalf("request %d was shed below the normal priority share", i+1)
+		}
+	}
+	if shedder.acquire("/test.Service/Method") {
+		t.Fatal("normal priority request was accepted above its share")
+	}
+	if !shedder.acquire("/grpc.health.v1.Health/Check") {
+		t.Fatal("critical request was shed below the limit")
+	}
+}
+
+func TestLoadShedderAIMD(t *testing.T) {
+	shedder := newTestShedder(loadSheddingAIMD, 10)
+
+	shedder.acquire("/test.Service/Method")
+	shedder.release(2*time.Second, false)
+	if shedder.limit != 5 {
+		t.Errorf("limit after slow request = %v, want 5", shedder.limit)
+	}
+
+	shedder.acquire("/test.Service/Method")
+	shedder.release(time.Millisecond, true)
+	if shedder.limit != 2.5 {
+		t.Errorf("limit after overload = %v, want 2.5", shedder.limit)
+	}
+}
+
+func TestLoadShedderReleasesOnPanic(t *testing.T) {
+	shedder := newTestShedder(loadSheddingAIMD, 10)
+	interceptor := shedder.unaryInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic was not propagated")
+			}
+		}()
+		interceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+			panic("boom")
+		})
+	}()
+
+	if shedder.inFlight != 0 {
+		t.Errorf("in-flight requests after panic = %d, want 0", shedder.inFlight)
+	}
+}
+
+func TestLoadShedderStreams(t *testing.T) {
+	shedder := newTestShedder(loadSheddingAIMD, 1)
+	interceptor := shedder.streamInterceptor()
+	info := &grpc.StreamServerInfo{FullMethod: "/test.Service/Watch"}
+	handler := func(interface{}, grpc.ServerStream) error { return nil }
+
+	if err := interceptor(nil, nil, info, handler); err != nil {
+		t.Fatalf("stream below the limit: %s", err)
+	}
+	if shedder.inFlight != 0 {
+		t.Errorf("in-flight requests after stream setup = %d, want 0", shedder.inFlight)
+	}
+
+	shedder.acquire("/test.Service/Method")
+	if err := interceptor(nil, nil, info, handler); status.Code(err) != codes.Unavailable {
+		t.Fatalf("stream above the limit: got %v, want Unavailable", err)
+	}
+}
+
+func TestConcurrencyLimitExport(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		metrics := newModuleMetrics("test")
+		metrics.loadShedding = enabled
+
+		want := 0
+		if enabled {
+			want = 1
+		}
+		if got := testutil.CollectAndCount(metrics, "grpc_server_concurrency_limit"); got != want {
+			t.Errorf("load shedding enabled = %v: exported %d concurrency limits, want %d", enabled, got, want)
+		}
+	}
+}
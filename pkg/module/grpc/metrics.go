@@ -0,0 +1,106 @@
+package grpc
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ModuleMetrics holds metrics produced by the module's own interceptors, as
+// opposed to the per-method RPC metrics in GRPCServerModule.Metrics.
+type ModuleMetrics struct {
+	rejections       *prometheus.CounterVec
+	timeouts         *prometheus.CounterVec
+	concurrencyLimit prometheus.Gauge
+	compressionRatio *prometheus.HistogramVec
+
+	// loadShedding is set during Configure; the concurrency limit is only
+	// exported when load shedding is enabled.
+	loadShedding bool
+}
+
+func (m *ModuleMetrics) collectors() []prometheus.Collector {
+	collectors := []prometheus.Collector{m.rejections, m.timeouts, m.compressionRatio}
+	if m.loadShedding {
+		collectors = append(collectors, m.concurrencyLimit)
+	}
+	return collectors
+}
+
+func (m *ModuleMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+func (m *ModuleMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// ClientModuleMetrics holds metrics produced by the client module itself, as
+// opposed to the per-method RPC metrics in GRPCClientModule.Metrics.
+type ClientModuleMetrics struct {
+	compressionRatio *prometheus.HistogramVec
+}
+
+func (m *ClientModuleMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.compressionRatio.Describe(ch)
+}
+
+func (m *ClientModuleMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.compressionRatio.Collect(ch)
+}
+
+var compressionRatioBuckets = []float64{1, 1.5, 2, 3, 4, 6, 8, 12, 16, 32}
+
+func newClientModuleMetrics(name string) *ClientModuleMetrics {
+	return &ClientModuleMetrics{
+		compressionRatio: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:        "grpc_client_compression_ratio",
+				Help:        "Ratio of uncompressed to compressed message size.",
+				ConstLabels: prometheus.Labels{"app": name},
+				Buckets:     compressionRatioBuckets,
+			},
+			[]string{"direction", "compressor"},
+		),
+	}
+}
+
+func newModuleMetrics(name string) *ModuleMetrics {
+	constLabels := prometheus.Labels{"app": name}
+	return &ModuleMetrics{
+		rejections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "grpc_server_limit_rejections_total",
+				Help:        "Total number of RPCs rejected by rate limits, concurrency limits or load shedding.",
+				ConstLabels: constLabels,
+			},
+			[]string{"grpc_method", "reason"},
+		),
+		timeouts: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "grpc_server_handler_timeouts_total",
+				Help:        "Total number of RPCs that exceeded a server-imposed deadline.",
+				ConstLabels: constLabels,
+			},
+			[]string{"grpc_method"},
+		),
+		concurrencyLimit: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name:        "grpc_server_concurrency_limit",
+				Help:        "Current adaptive concurrency limit used for load shedding.",
+				ConstLabels: constLabels,
+			},
+		),
+		compressionRatio: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:        "grpc_server_compression_ratio",
+				Help:        "Ratio of uncompressed to compressed message size.",
+				ConstLabels: constLabels,
+				Buckets:     compressionRatioBuckets,
+			},
+			[]string{"direction", "compressor"},
+		),
+	}
+}
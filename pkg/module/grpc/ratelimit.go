@@ -0,0 +1,266 @@
+package grpc
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	limitReasonRate        = "rate"
+	limitReasonConcurrency = "concurrency"
+
+	// Per-client limiter state is kept for at most this many clients; the
+	// least recently seen client without requests in flight is dropped to
+	// make room for a new one.
+	maxTrackedClients = 10000
+
+	// concurrencyRetryAfter is the retry delay suggested to clients rejected
+	// by a concurrency limit. When a slot frees up isn't known in advance.
+	concurrencyRetryAfter = time.Second
+)
+
+type RateLimitRule struct {
+	Method      string
+	Rate        float64
+	Burst       int
+	MaxInFlight int
+	PerClient   bool
+}
+
+func parseRateLimitRules(configPrefix string) ([]RateLimitRule, error) {
+	var rules []RateLimitRule
+	key := fmt.Sprintf("%s.rateLimits", configPrefix)
+
+	err := viper.UnmarshalKey(key, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %s: %s", key, err)
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Method == "" {
+			return nil, fmt.Errorf("invalid configuration: %s entries need a method", key)
+		}
+		if rule.Rate < 0 || rule.Burst < 0 || rule.MaxInFlight < 0 {
+			return nil, fmt.Errorf("invalid configuration: %s for %s can't have negative limits", key, rule.Method)
+		}
+		if rule.Rate == 0 && rule.MaxInFlight == 0 {
+			return nil, fmt.Errorf("invalid configuration: %s for %s must set rate or maxInFlight", key, rule.Method)
+		}
+		if rule.Rate > 0 && rule.Burst == 0 {
+			rule.Burst = int(rule.Rate)
+			if rule.Burst < 1 {
+				rule.Burst = 1
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+type limiterState struct {
+	client   string
+	bucket   *rate.Limiter
+	inFlight chan struct{}
+	// users counts the requests holding the state. It is guarded by the
+	// rule's lock, and states in use are never evicted so that a client
+	// can't get a fresh set of concurrency slots.
+	users int
+}
+
+type limiterRule struct {
+	RateLimitRule
+
+	mu      sync.Mutex
+	clients map[string]*list.Element
+	// recent orders the clients from most to least recently seen.
+	recent *list.List
+}
+
+// state returns the client's limiter state and holds it until put is called.
+func (r *limiterRule) state(client string) *limiterState {
+	if !r.PerClient {
+		client = ""
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if element, ok := r.clients[client]; ok {
+		r.recent.MoveToFront(element)
+		state := element.Value.(*limiterState)
+		state.users++
+		return state
+	}
+
+	if r.recent.Len() >= maxTrackedClients {
+		r.evict()
+	}
+
+	state := &limiterState{client: client, users: 1}
+	if r.Rate > 0 {
+		state.bucket = rate.NewLimiter(rate.Limit(r.Rate), r.Burst)
+	}
+	if r.MaxInFlight > 0 {
+		state.inFlight = make(chan struct{}, r.MaxInFlight)
+	}
+	r.clients[client] = r.recent.PushFront(state)
+
+	return state
+}
+
+// put releases a state returned by state.
+func (r *limiterRule) put(state *limiterState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state.users--
+}
+
+// evict drops the least recently seen state that isn't in use. When every
+// state is in use, nothing is dropped and the rule temporarily tracks more
+// than maxTrackedClients clients.
+func (r *limiterRule) evict() {
+	for element := r.recent.Back(); element != nil; element = element.Prev() {
+		state := element.Value.(*limiterState)
+		if state.users == 0 {
+			r.recent.Remove(element)
+			delete(r.clients, state.client)
+			return
+		}
+	}
+}
+
+type rateLimiter struct {
+	rules   []*limiterRule
+	metrics *ModuleMetrics
+}
+
+func newRateLimiter(rules []RateLimitRule, metrics *ModuleMetrics) *rateLimiter {
+	limiter := &rateLimiter{metrics: metrics}
+	for _, rule := range rules {
+		limiter.rules = append(limiter.rules, &limiterRule{RateLimitRule: rule, clients: make(map[string]*list.Element), recent: list.New()})
+	}
+	return limiter
+}
+
+// clientIdentity prefers the authenticated principal and falls back to the
+// peer's IP address.
+func clientIdentity(ctx context.Context) string {
+	if principal, ok := PrincipalFromContext(ctx); ok && principal.Name != "" {
+		return principal.Provider + ":" + principal.Name
+	}
+	if pr, ok := peer.FromContext(ctx); ok {
+		host, _, err := net.SplitHostPort(pr.Addr.String())
+		if err != nil {
+			return pr.Addr.String()
+		}
+		return host
+	}
+	return ""
+}
+
+func resourceExhausted(message string, retryAfter time.Duration) error {
+	st := status.New(codes.ResourceExhausted, message)
+	if retryAfter > 0 {
+		detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+		if err == nil {
+			return detailed.Err()
+		}
+	}
+	return st.Err()
+}
+
+// acquire applies every rule matching method and returns a release function
+// for the concurrency slots and limiter states taken. When any rule rejects
+// the request, the
+// tokens already reserved from other rules are returned. Reservations are
+// cancelled at the time they were made, since the limiter doesn't refund
+// tokens for reservations that already took effect.
+func (l *rateLimiter) acquire(ctx context.Context, method string) (func(), error) {
+	now := time.Now()
+	var taken []chan struct{}
+	var reservations []*rate.Reservation
+	held := make(map[*limiterRule]*limiterState)
+	release := func() {
+		for _, slots := range taken {
+			<-slots
+		}
+		for rule, state := range held {
+			rule.put(state)
+		}
+	}
+	reject := func() {
+		for _, reservation := range reservations {
+			reservation.CancelAt(now)
+		}
+		release()
+	}
+
+	client := clientIdentity(ctx)
+	for _, rule := range l.rules {
+		if !matchMethod(rule.Method, method) {
+			continue
+		}
+		state := rule.state(client)
+		held[rule] = state
+
+		if state.bucket != nil {
+			reservation := state.bucket.ReserveN(now, 1)
+			reservations = append(reservations, reservation)
+			delay := reservation.DelayFrom(now)
+			if delay > 0 {
+				reject()
+				l.metrics.rejections.WithLabelValues(method, limitReasonRate).Inc()
+				return nil, resourceExhausted(fmt.Sprintf("rate limit exceeded for %s", method), delay)
+			}
+		}
+
+		if state.inFlight != nil {
+			select {
+			case state.inFlight <- struct{}{}:
+				taken = append(taken, state.inFlight)
+			default:
+				reject()
+				l.metrics.rejections.WithLabelValues(method, limitReasonConcurrency).Inc()
+				return nil, resourceExhausted(fmt.Sprintf("too many concurrent requests for %s", method), concurrencyRetryAfter)
+			}
+		}
+	}
+
+	return release, nil
+}
+
+func (l *rateLimiter) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		release, err := l.acquire(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+func (l *rateLimiter) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := l.acquire(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,228 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func clientContext(address string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(address), Port: 50000}})
+}
+
+func TestRateLimiterAcquire(t *testing.T) {
+	type request struct {
+		client string
+		method string
+		// hold keeps the request in flight until the end of the case.
+		hold bool
+		want codes.Code
+	}
+
+	tests := []struct {
+		name     string
+		rules    []RateLimitRule
+		requests []request
+	}{
+		{
+			name:  "rate",
+			rules: []RateLimitRule{{Method: "/test.Service/*", Rate: 0.001, Burst: 2}},
+			requests: []request{
+				{client: "10.0.0.1", method: "/test.Service/A", want: codes.OK},
+				{client: "10.0.0.2", method: "/test.Service/B", want: codes.OK},
+				{client: "10.0.0.1", method: "/test.Service/A", want: codes.ResourceExhausted},
+				{client: "10.0.0.1", method: "/other.Service/A", want: codes.OK},
+			},
+		},
+		{
+			name:  "rate per client",
+			rules: []RateLimitRule{{Method: "/test.Service/*", Rate: 0.001, Burst: 1, PerClient: true}},
+			requests: []request{
+				{client: "10.0.0.1", method: "/test.Service/A", want: codes.OK},
+				{client: "10.0.0.2", method: "/test.Service/A", want: codes.OK},
+				{client: "10.0.0.1", method: "/test.Service/A", want: codes.ResourceExhausted},
+			},
+		},
+		{
+			name:  "concurrency",
+			rules: []RateLimitRule{{Method: "/test.Service/A", MaxInFlight: 1}},
+			requests: []request{
+				{client: "10.0.0.1", method: "/test.Service/A", hold: true, want: codes.OK},
+				{client: "10.0.0.2", method: "/test.Service/A", want: codes.ResourceExhausted},
+				{client: "10.0.0.2", method: "/test.Service/B", want: codes.OK},
+			},
+		},
+		{
+			name:  "concurrency slot is released",
+			rules: []RateLimitRule{{Method: "/test.Service/A", MaxInFlight: 1}},
+			requests: []request{
+				{client: "10.0.0.1", method: "/test.Service/A", want: codes.OK},
+				{client: "10.0.0.1", method: "/test.Service/A", want: codes.OK},
+			},
+		},
+		{
+			name: "token refunded when a later rule rejects",
+			rules: []RateLimitRule{
+				{Method: "/test.Service/*", Rate: 0.001, Burst: 2},
+				{Method: "/test.Service/A", Rate: 0.001, Burst: 1},
+			},
+			requests: []request{
+				{client: "10.0.0.1", method: "/test.Service/A", want: codes.OK},
+				{client: "10.0.0.1", method: "/test.Service/A", want: codes.ResourceExhausted},
+				{client: "10.0.0.1", method: "/test.Service/B", want: codes.OK},
+				{client: "10.0.0.1", method: "/test.Service/B", want: codes.ResourceExhausted},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newRateLimiter(tt.rules, newModuleMetrics("test"))
+
+			var held []func()
+			defer func() {
+				for _, release := range held {
+					release()
+				}
+			}()
+
+			for i, req := range tt.requests {
+				release, err := limiter.acquire(clientContext(req.client), req.method)
+				if got := status.Code(err); got != req.want {
+					t.Fatalf("request %d from %s to %s: got %s, want %s", i+1, req.client, req.method, got, req.want)
+				}
+				if err != nil {
+					continue
+				}
+				if req.hold {
+					held = append(held, release)
+				} else {
+					release()
+				}
+			}
+		})
+	}
+}
+
+// A request rejected by a concurrency limit must not use up a token of a
+// rate limit that allowed it.
+func TestRateLimiterRefundsTokens(t *testing.T) {
+	limiter := newRateLimiter([]RateLimitRule{
+		{Method: "/test.Service/*", Rate: 0.001, Burst: 2},
+		{Method: "/test.Service/A", MaxInFlight: 1},
+	}, newModuleMetrics("test"))
+	ctx := clientContext("10.0.0.1")
+
+	release, err := limiter.acquire(ctx, "/test.Service/A")
+	if err != nil {
+		t.Fatalf("first request: %s", err)
+	}
+
+	_, err = limiter.acquire(ctx, "/test.Service/A")
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("second request: got %v, want ResourceExhausted", err)
+	}
+	release()
+
+	// One token is left only if the rejected request returned its token.
+	release, err = limiter.acquire(ctx, "/test.Service/A")
+	if err != nil {
+		t.Fatalf("third request: %s", err)
+	}
+	release()
+}
+
+func TestRateLimiterBoundsTrackedClients(t *testing.T) {
+	limiter := newRateLimiter([]RateLimitRule{{Method: "*", Rate: 0.001, Burst: 1, PerClient: true}}, newModuleMetrics("test"))
+	rule := limiter.rules[0]
+
+	touch := func(client string) {
+		rule.put(rule.state(client))
+	}
+
+	for i := 0; i <= maxTrackedClients; i++ {
+		touch(fmt.Sprintf("client-%d", i))
+	}
+	// Touch the second oldest client so that it survives the next eviction,
+	// and keep the third oldest in use.
+	touch("client-1")
+	inUse := rule.state("client-2")
+	touch("client-new")
+
+	if len(rule.clients) != maxTrackedClients || rule.recent.Len() != maxTrackedClients {
+		t.Fatalf("tracked clients = %d (list %d), want %d", len(rule.clients), rule.recent.Len(), maxTrackedClients)
+	}
+	for _, client := range []string{"client-0", "client-3"} {
+		if _, ok := rule.clients[client]; ok {
+			t.Errorf("%s was not evicted", client)
+		}
+	}
+	rule.put(inUse)
+	for _, client := range []string{"client-1", "client-2", "client-new", fmt.Sprintf("client-%d", maxTrackedClients)} {
+		if _, ok := rule.clients[client]; !ok {
+			t.Errorf("%s was evicted", client)
+		}
+	}
+}
+
+// A client with requests in flight must keep its concurrency slots however
+// many other clients show up.
+func TestRateLimiterKeepsClientsInFlight(t *testing.T) {
+	limiter := newRateLimiter([]RateLimitRule{{Method: "*", MaxInFlight: 1, PerClient: true}}, newModuleMetrics("test"))
+	rule := limiter.rules[0]
+
+	release, err := limiter.acquire(clientContext("10.0.0.1"), "/test.Service/A")
+	if err != nil {
+		t.Fatalf("first request: %s", err)
+	}
+	defer release()
+
+	for i := 0; i < maxTrackedClients; i++ {
+		rule.put(rule.state(fmt.Sprintf("client-%d", i)))
+	}
+
+	_, err = limiter.acquire(clientContext("10.0.0.1"), "/test.Service/A")
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("second request: got %v, want ResourceExhausted", err)
+	}
+}
+
+func TestRateLimiterRetryInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		rule RateLimitRule
+	}{
+		{name: "rate", rule: RateLimitRule{Method: "*", Rate: 0.001, Burst: 1}},
+		{name: "concurrency", rule: RateLimitRule{Method: "*", MaxInFlight: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newRateLimiter([]RateLimitRule{tt.rule}, newModuleMetrics("test"))
+			ctx := clientContext("10.0.0.1")
+
+			release, err := limiter.acquire(ctx, "/test.Service/A")
+			if err != nil {
+				t.Fatalf("first request: %s", err)
+			}
+			defer release()
+
+			_, err = limiter.acquire(ctx, "/test.Service/A")
+			var retryInfo *errdetails.RetryInfo
+			for _, detail := range status.Convert(err).Details() {
+				if info, ok := detail.(*errdetails.RetryInfo); ok {
+					retryInfo = info
+				}
+			}
+			if retryInfo == nil || retryInfo.RetryDelay.AsDuration() <= 0 {
+				t.Fatalf("rejection %v has no retry delay", err)
+			}
+		})
+	}
+}
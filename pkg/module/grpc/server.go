@@ -2,17 +2,26 @@ package grpc
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"math"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/charmbracelet/log"
 	grpcprom "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/viper"
 	"google.golang.org/grpc"
+	channelzsvc "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
 
 	"github.com/dnikishov/microboiler/pkg/module"
+	"github.com/dnikishov/microboiler/pkg/module/listener"
 )
 
 type RegistryEntry struct {
@@ -22,18 +31,66 @@ type RegistryEntry struct {
 
 type Options struct {
 	ServiceRegistry []RegistryEntry
+
+	// Interceptors supplied here run after all built-in interceptors, in the
+	// order given. Built-in interceptors are, outermost first: compression,
+	// in-flight request tracking, metrics, request ID, access logging, panic
+	// recovery, error mapping, server-side deadlines, authentication, rate
+	// limiting, load shedding and request validation.
+	UnaryInterceptors  []grpc.UnaryServerInterceptor
+	StreamInterceptors []grpc.StreamServerInterceptor
+
+	// AuthProviders are tried after the providers configured under
+	// grpc-<name>.auth, which must be enabled for them to be used.
+	AuthProviders []AuthProvider
+
+	// ErrorRegistry maps errors returned by services to gRPC statuses.
+	// NewErrorRegistry is used when nil.
+	ErrorRegistry *ErrorRegistry
+
+	// ServerOptions are appended after the options derived from configuration
+	// and may override them.
+	ServerOptions []grpc.ServerOption
 }
 
 type GRPCServerModule struct {
 	module.Base
 	server          *grpc.Server
+	credentials     grpc.ServerOption
+	health          *health.Server
 	options         *Options
 	ctx             context.Context
-	listenAddress   string
+	listeners       []ListenerConfig
+	sharedListener  string
 	exportMetrics   bool
+	reflection      bool
+	channelz        bool
 	keepaliveParams keepalive.ServerParameters
+	keepalivePolicy keepalive.EnforcementPolicy
+	transport       TransportConfig
+	tlsConfig       *TLSConfig
+	interceptors    InterceptorsConfig
+	authConfig      *AuthConfig
+	rateLimitRules  []RateLimitRule
+	deadlineConfig  *DeadlineConfig
+	loadShedding    *LoadSheddingConfig
+	compression     *CompressionConfig
+	validation      ValidationConfig
+	errorMapping    ErrorMappingConfig
+	shutdown        ShutdownConfig
+	inFlight        inFlightTracker
+
+	inProcessServer   *grpc.Server
+	inProcessListener *pipeListener
+	inProcessOnly     bool
+	forwardingToken   string
 
-	Metrics *grpcprom.ServerMetrics
+	healthMu       sync.Mutex
+	healthReported map[string]bool
+	draining       chan struct{}
+
+	Metrics       *grpcprom.ServerMetrics
+	ModuleMetrics *ModuleMetrics
 }
 
 func (p *GRPCServerModule) parseKeepaliveParams() {
@@ -46,20 +103,196 @@ func (p *GRPCServerModule) parseKeepaliveParams() {
 	p.keepaliveParams.Timeout = viper.GetDuration(fmt.Sprintf("%s.keepalive.timeout", configPrefix))
 }
 
+type TransportConfig struct {
+	MaxRecvMsgSize       int
+	MaxSendMsgSize       int
+	MaxConcurrentStreams uint32
+	ConnectionTimeout    time.Duration
+	WriteBufferSize      int
+	ReadBufferSize       int
+	MaxHeaderListSize    uint32
+}
+
+func (p *GRPCServerModule) parseKeepalivePolicy() error {
+	configPrefix := fmt.Sprintf("grpc-%s", p.GetName())
+
+	p.keepalivePolicy.MinTime = viper.GetDuration(fmt.Sprintf("%s.keepalive.enforcement.minTime", configPrefix))
+	p.keepalivePolicy.PermitWithoutStream = viper.GetBool(fmt.Sprintf("%s.keepalive.enforcement.permitWithoutStream", configPrefix))
+
+	if p.keepalivePolicy.MinTime < 0 {
+		return fmt.Errorf("invalid configuration: %s.keepalive.enforcement.minTime can't be less than 0", configPrefix)
+	}
+
+	return nil
+}
+
+func (p *GRPCServerModule) parseTransportConfig() error {
+	configPrefix := fmt.Sprintf("grpc-%s", p.GetName())
+
+	sizes := map[string]*int{
+		"maxRecvMsgSize":  &p.transport.MaxRecvMsgSize,
+		"maxSendMsgSize":  &p.transport.MaxSendMsgSize,
+		"writeBufferSize": &p.transport.WriteBufferSize,
+		"readBufferSize":  &p.transport.ReadBufferSize,
+	}
+	for key, target := range sizes {
+		fullKey := fmt.Sprintf("%s.%s", configPrefix, key)
+		if !viper.IsSet(fullKey) {
+			*target = -1
+			continue
+		}
+		size := viper.GetSizeInBytes(fullKey)
+		if size == 0 && key != "writeBufferSize" && key != "readBufferSize" {
+			return fmt.Errorf("invalid configuration: %s must be greater than 0", fullKey)
+		}
+		if size > math.MaxInt32 {
+			return fmt.Errorf("invalid configuration: %s is too large", fullKey)
+		}
+		*target = int(size)
+	}
+
+	maxConcurrentStreams := viper.GetInt64(fmt.Sprintf("%s.maxConcurrentStreams", configPrefix))
+	if maxConcurrentStreams < 0 || maxConcurrentStreams > math.MaxUint32 {
+		return fmt.Errorf("invalid configuration: %s.maxConcurrentStreams is out of range", configPrefix)
+	}
+	p.transport.MaxConcurrentStreams = uint32(maxConcurrentStreams)
+
+	maxHeaderListSize := viper.GetSizeInBytes(fmt.Sprintf("%s.maxHeaderListSize", configPrefix))
+	if maxHeaderListSize > math.MaxUint32 {
+		return fmt.Errorf("invalid configuration: %s.maxHeaderListSize is out of range", configPrefix)
+	}
+	p.transport.MaxHeaderListSize = uint32(maxHeaderListSize)
+
+	p.transport.ConnectionTimeout = viper.GetDuration(fmt.Sprintf("%s.connectionTimeout", configPrefix))
+	if p.transport.ConnectionTimeout < 0 {
+		return fmt.Errorf("invalid configuration: %s.connectionTimeout can't be less than 0", configPrefix)
+	}
+
+	return nil
+}
+
+func (p *GRPCServerModule) transportOptions() []grpc.ServerOption {
+	options := []grpc.ServerOption{grpc.KeepaliveEnforcementPolicy(p.keepalivePolicy)}
+
+	if p.transport.MaxRecvMsgSize >= 0 {
+		options = append(options, grpc.MaxRecvMsgSize(p.transport.MaxRecvMsgSize))
+	}
+	if p.transport.MaxSendMsgSize >= 0 {
+		options = append(options, grpc.MaxSendMsgSize(p.transport.MaxSendMsgSize))
+	}
+	if p.transport.WriteBufferSize >= 0 {
+		options = append(options, grpc.WriteBufferSize(p.transport.WriteBufferSize))
+	}
+	if p.transport.ReadBufferSize >= 0 {
+		options = append(options, grpc.ReadBufferSize(p.transport.ReadBufferSize))
+	}
+	if p.transport.MaxConcurrentStreams > 0 {
+		options = append(options, grpc.MaxConcurrentStreams(p.transport.MaxConcurrentStreams))
+	}
+	if p.transport.MaxHeaderListSize > 0 {
+		options = append(options, grpc.MaxHeaderListSize(p.transport.MaxHeaderListSize))
+	}
+	if p.transport.ConnectionTimeout > 0 {
+		options = append(options, grpc.ConnectionTimeout(p.transport.ConnectionTimeout))
+	}
+
+	return options
+}
+
 func (p *GRPCServerModule) Configure() error {
 	configPrefix := fmt.Sprintf("grpc-%s", p.GetName())
-	listenAddress := viper.GetString(fmt.Sprintf("%s.listenAddress", configPrefix))
 	exportMetrics := viper.GetBool(fmt.Sprintf("%s.exportMetrics", configPrefix))
 
-	if listenAddress == "" {
-		return fmt.Errorf("invalid configuration: %s.listenAddress is not set", configPrefix)
+	sharedListener := viper.GetString(fmt.Sprintf("%s.sharedListener", configPrefix))
+
+	listeners, err := parseListenerConfigs(configPrefix, sharedListener != "" || p.inProcessOnly)
+	if err != nil {
+		return err
+	}
+	if p.inProcessOnly {
+		listeners = nil
 	}
 
-	p.listenAddress = listenAddress
+	p.listeners = listeners
+	p.sharedListener = sharedListener
 	p.exportMetrics = exportMetrics
+	if exportMetrics {
+		module.RegisterCollector(fmt.Sprintf("grpc-%s", p.GetName()), p.Metrics)
+		module.RegisterCollector(fmt.Sprintf("grpc-%s-module", p.GetName()), p.ModuleMetrics)
+	}
+	p.reflection = viper.GetBool(fmt.Sprintf("%s.reflection", configPrefix))
+	p.channelz = viper.GetBool(fmt.Sprintf("%s.channelz", configPrefix))
+	p.interceptors = parseInterceptorsConfig(configPrefix)
+	p.validation = parseValidationConfig(configPrefix)
+	p.errorMapping = parseErrorMappingConfig(configPrefix, p.GetName())
 
 	p.parseKeepaliveParams()
 
+	err = p.parseKeepalivePolicy()
+	if err != nil {
+		return err
+	}
+
+	err = p.parseTransportConfig()
+	if err != nil {
+		return err
+	}
+
+	tlsConfig, err := parseTLSConfig(configPrefix)
+	if err != nil {
+		return err
+	}
+	p.tlsConfig = tlsConfig
+
+	authConfig, err := parseAuthConfig(configPrefix)
+	if err != nil {
+		return err
+	}
+	if authConfig.PeerIdentity && (tlsConfig == nil || tlsConfig.ClientAuth == tls.NoClientCert) {
+		return fmt.Errorf("invalid configuration: %s.auth.peerIdentity requires TLS with client certificates", configPrefix)
+	}
+	p.authConfig = authConfig
+
+	// The shared listener terminates TLS itself, so the server would neither
+	// use its own certificates nor see verified client certificates.
+	if sharedListener != "" && tlsConfig != nil {
+		return fmt.Errorf("invalid configuration: %s.tls can't be used with %s.sharedListener, configure TLS on the shared listener", configPrefix, configPrefix)
+	}
+	if sharedListener != "" && authConfig.PeerIdentity {
+		return fmt.Errorf("invalid configuration: %s.auth.peerIdentity can't be used with %s.sharedListener", configPrefix, configPrefix)
+	}
+
+	rateLimitRules, err := parseRateLimitRules(configPrefix)
+	if err != nil {
+		return err
+	}
+	p.rateLimitRules = rateLimitRules
+
+	deadlineConfig, err := parseDeadlineConfig(configPrefix)
+	if err != nil {
+		return err
+	}
+	p.deadlineConfig = deadlineConfig
+
+	compression, err := parseCompressionConfig(configPrefix)
+	if err != nil {
+		return err
+	}
+	p.compression = compression
+
+	loadShedding, err := parseLoadSheddingConfig(configPrefix)
+	if err != nil {
+		return err
+	}
+	p.loadShedding = loadShedding
+	p.ModuleMetrics.loadShedding = loadShedding.Enabled
+
+	shutdown, err := parseShutdownConfig(configPrefix)
+	if err != nil {
+		return err
+	}
+	p.shutdown = shutdown
+
 	for _, entry := range p.options.ServiceRegistry {
 		configurableSvc, ok := entry.Service.(module.Configurable)
 		if ok {
@@ -93,8 +326,27 @@ func (p *GRPCServerModule) PeriodicTasks() []*module.TaskConfig {
 func (p *GRPCServerModule) Init(ctx context.Context) error {
 	p.ctx = ctx
 	serverOptions := []grpc.ServerOption{grpc.KeepaliveParams(p.keepaliveParams)}
-	unaryInterceptors := []grpc.UnaryServerInterceptor{}
-	streamInterceptors := []grpc.StreamServerInterceptor{}
+	serverOptions = append(serverOptions, p.transportOptions()...)
+
+	if p.tlsConfig != nil {
+		reloader, err := newCertReloader(p.GetName(), p.tlsConfig)
+		if err != nil {
+			return err
+		}
+		p.credentials = grpc.Creds(credentials.NewTLS(reloader.serverConfig()))
+	}
+
+	var unaryInterceptors []grpc.UnaryServerInterceptor
+	var streamInterceptors []grpc.StreamServerInterceptor
+
+	if p.compression.enabled() {
+		enforcer := &compressionEnforcer{config: p.compression}
+		unaryInterceptors = append(unaryInterceptors, enforcer.unaryInterceptor())
+		streamInterceptors = append(streamInterceptors, enforcer.streamInterceptor())
+	}
+
+	unaryInterceptors = append(unaryInterceptors, p.inFlight.unaryInterceptor())
+	streamInterceptors = append(streamInterceptors, p.inFlight.streamInterceptor())
 
 	if p.exportMetrics {
 		unaryInterceptors = append(
@@ -108,6 +360,82 @@ func (p *GRPCServerModule) Init(ctx context.Context) error {
 		)
 	}
 
+	if p.interceptors.RequestID {
+		unaryInterceptors = append(unaryInterceptors, requestIDUnaryInterceptor())
+		streamInterceptors = append(streamInterceptors, requestIDStreamInterceptor())
+	}
+
+	if p.interceptors.AccessLog {
+		unaryInterceptors = append(unaryInterceptors, p.accessLogUnaryInterceptor())
+		streamInterceptors = append(streamInterceptors, p.accessLogStreamInterceptor())
+	}
+
+	if p.interceptors.Recovery {
+		unaryInterceptors = append(unaryInterceptors, p.recoveryUnaryInterceptor())
+		streamInterceptors = append(streamInterceptors, p.recoveryStreamInterceptor())
+	}
+
+	if p.errorMapping.Enabled {
+		registry := p.options.ErrorRegistry
+		if registry == nil {
+			registry = NewErrorRegistry()
+		}
+		mapper := &errorMapper{name: p.GetName(), config: p.errorMapping, registry: registry}
+		unaryInterceptors = append(unaryInterceptors, mapper.unaryInterceptor())
+		streamInterceptors = append(streamInterceptors, mapper.streamInterceptor())
+	}
+
+	if p.exportMetrics || p.compression.enabled() {
+		serverOptions = append(serverOptions, grpc.StatsHandler(&compressionHandler{ratio: p.ModuleMetrics.compressionRatio}))
+	}
+
+	maxRecvMsgSize := p.transport.MaxRecvMsgSize
+	if maxRecvMsgSize < 0 {
+		maxRecvMsgSize = defaultMaxRecvMsgSize
+	}
+	raiseZstdMaxWindow(maxRecvMsgSize)
+
+	if p.deadlineConfig.enabled() {
+		enforcer := &deadlineEnforcer{config: p.deadlineConfig, metrics: p.ModuleMetrics}
+		unaryInterceptors = append(unaryInterceptors, enforcer.unaryInterceptor())
+		streamInterceptors = append(streamInterceptors, enforcer.streamInterceptor())
+	}
+
+	if p.authConfig.Enabled {
+		authenticator, err := p.newAuthenticator()
+		if err != nil {
+			return err
+		}
+		unaryInterceptors = append(unaryInterceptors, authenticator.unaryInterceptor())
+		streamInterceptors = append(streamInterceptors, authenticator.streamInterceptor())
+	}
+
+	if len(p.rateLimitRules) > 0 {
+		limiter := newRateLimiter(p.rateLimitRules, p.ModuleMetrics)
+		unaryInterceptors = append(unaryInterceptors, limiter.unaryInterceptor())
+		streamInterceptors = append(streamInterceptors, limiter.streamInterceptor())
+	}
+
+	// Requests rejected by authentication or rate limiting never reach the
+	// load shedder, so they don't count towards its latency samples.
+	if p.loadShedding.Enabled {
+		shedder := newLoadShedder(p.loadShedding, p.ModuleMetrics)
+		unaryInterceptors = append(unaryInterceptors, shedder.unaryInterceptor())
+		streamInterceptors = append(streamInterceptors, shedder.streamInterceptor())
+	}
+
+	if p.validation.Enabled {
+		validator, err := newRequestValidator(p.GetName(), p.validation)
+		if err != nil {
+			return err
+		}
+		unaryInterceptors = append(unaryInterceptors, validator.unaryInterceptor())
+		streamInterceptors = append(streamInterceptors, validator.streamInterceptor())
+	}
+
+	unaryInterceptors = append(unaryInterceptors, p.options.UnaryInterceptors...)
+	streamInterceptors = append(streamInterceptors, p.options.StreamInterceptors...)
+
 	if len(unaryInterceptors) > 0 {
 		unaryInterceptorOpt := grpc.ChainUnaryInterceptor(unaryInterceptors...)
 		serverOptions = append(serverOptions, unaryInterceptorOpt)
@@ -118,36 +446,112 @@ func (p *GRPCServerModule) Init(ctx context.Context) error {
 		serverOptions = append(serverOptions, streamInterceptorOpt)
 	}
 
+	serverOptions = append(serverOptions, p.options.ServerOptions...)
+	p.initInProcess(serverOptions)
+
+	if p.credentials != nil {
+		serverOptions = append(serverOptions, p.credentials)
+	}
+
 	p.server = grpc.NewServer(serverOptions...)
 	p.registerServices()
+	p.registerHealth()
 
-	log.Info("GRPC server initialized", "name", p.GetName(), "address", p.listenAddress)
+	// Create per-method series up front so that they are exported with zero
+	// values before the first call.
+	if p.exportMetrics {
+		p.Metrics.InitializeMetrics(p.server)
+	}
+
+	// When attached to a shared listener the server is driven through its
+	// http.Handler implementation, which lacks some transport features such
+	// as keepalive enforcement.
+	if p.sharedListener != "" {
+		shared, err := listener.Lookup(p.sharedListener)
+		if err != nil {
+			return err
+		}
+		err = shared.HandleGRPC(p.server)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info("GRPC server initialized", "name", p.GetName(), "listeners", len(p.listeners), "tls", p.tlsConfig != nil)
 
 	return nil
 }
 
 func (p *GRPCServerModule) Main(_ context.Context) error {
-	listener, err := net.Listen("tcp", p.listenAddress)
-	if err != nil {
-		return err
+	listeners := make([]net.Listener, 0, len(p.listeners))
+	for _, config := range p.listeners {
+		lis, err := listen(config)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, lis)
 	}
-	log.Info("Starting GRPC server", "name", p.GetName(), "address", p.listenAddress)
-	err = p.server.Serve(listener)
-	if err != nil {
-		return err
+
+	p.markServing()
+
+	servers := len(listeners)
+	errCh := make(chan error, servers+1)
+	if p.inProcessServer != nil {
+		servers++
+		go func() {
+			errCh <- p.inProcessServer.Serve(p.inProcessListener)
+		}()
 	}
-	return nil
+
+	for i := range listeners {
+		lis := listeners[i]
+		log.Info("Starting GRPC server", "name", p.GetName(), "address", p.listeners[i].Address)
+		go func() {
+			errCh <- p.server.Serve(lis)
+		}()
+	}
+
+	var err error
+	for i := 0; i < servers; i++ {
+		serveErr := <-errCh
+		if serveErr != nil && err == nil {
+			err = serveErr
+			p.server.Stop()
+			if p.inProcessServer != nil {
+				p.inProcessServer.Stop()
+			}
+		}
+	}
+	return err
 }
 
 func (p *GRPCServerModule) Cleanup(_ context.Context) {
 	log.Info("Stopping GRPC server", "name", p.GetName())
-	p.server.Stop()
+	// Unix socket files are unlinked when the server closes its listeners.
+	p.drain()
 }
 
 func (p *GRPCServerModule) registerServices() {
 	for _, entry := range p.options.ServiceRegistry {
 		p.server.RegisterService(&entry.ServiceDesc, entry.Service)
 	}
+
+	if p.reflection {
+		log.Info("Registering GRPC reflection service", "name", p.GetName())
+		reflection.Register(p.server)
+	}
+
+	if p.channelz {
+		log.Info("Registering GRPC channelz service", "name", p.GetName())
+		channelzsvc.RegisterChannelzServiceToServer(p.server)
+	}
+}
+
+func (p *GRPCServerModule) Services() []RegistryEntry {
+	return p.options.ServiceRegistry
 }
 
 func NewGRPCServerModule(name string, options *Options) *GRPCServerModule {
@@ -157,5 +561,5 @@ func NewGRPCServerModule(name string, options *Options) *GRPCServerModule {
 			grpcprom.WithHistogramBuckets([]float64{0.001, 0.01, 0.1, 0.3, 0.6, 1, 3, 6, 9, 20, 30, 60, 90, 120}),
 		),
 	)
-	return &GRPCServerModule{Base: module.Base{Name: name, IncludesInit: true, IncludesCleanup: true, IncludesMain: true}, options: options, Metrics: metrics}
+	return &GRPCServerModule{Base: module.Base{Name: name, IncludesInit: true, IncludesCleanup: true, IncludesMain: true}, options: options, Metrics: metrics, ModuleMetrics: newModuleMetrics(name)}
 }
@@ -0,0 +1,239 @@
+package grpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/viper"
+)
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	clientAuthModes = map[string]tls.ClientAuthType{
+		"none":     tls.NoClientCert,
+		"optional": tls.VerifyClientCertIfGiven,
+		"required": tls.RequireAndVerifyClientCert,
+	}
+)
+
+type TLSConfig struct {
+	CertFile       string
+	KeyFile        string
+	ClientCAFile   string
+	ClientAuth     tls.ClientAuthType
+	MinVersion     uint16
+	CipherSuites   []uint16
+	ReloadInterval time.Duration
+}
+
+func parseTLSConfig(configPrefix string) (*TLSConfig, error) {
+	tlsPrefix := fmt.Sprintf("%s.tls", configPrefix)
+
+	certFile := viper.GetString(fmt.Sprintf("%s.certFile", tlsPrefix))
+	keyFile := viper.GetString(fmt.Sprintf("%s.keyFile", tlsPrefix))
+	clientCAFile := viper.GetString(fmt.Sprintf("%s.clientCAFile", tlsPrefix))
+	clientAuth := viper.GetString(fmt.Sprintf("%s.clientAuth", tlsPrefix))
+	minVersion := viper.GetString(fmt.Sprintf("%s.minVersion", tlsPrefix))
+	cipherSuites := viper.GetStringSlice(fmt.Sprintf("%s.cipherSuites", tlsPrefix))
+	reloadInterval := viper.GetDuration(fmt.Sprintf("%s.reloadInterval", tlsPrefix))
+
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" {
+			return nil, fmt.Errorf("invalid configuration: %s.clientCAFile requires certFile and keyFile", tlsPrefix)
+		}
+		return nil, nil
+	}
+
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("invalid configuration: both %s.certFile and %s.keyFile must be set", tlsPrefix, tlsPrefix)
+	}
+
+	config := &TLSConfig{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		ClientCAFile:   clientCAFile,
+		ReloadInterval: reloadInterval,
+	}
+
+	if clientAuth == "" {
+		if clientCAFile != "" {
+			clientAuth = "required"
+		} else {
+			clientAuth = "none"
+		}
+	}
+
+	authType, ok := clientAuthModes[clientAuth]
+	if !ok {
+		return nil, fmt.Errorf("invalid configuration: %s.clientAuth must be one of none, optional, required", tlsPrefix)
+	}
+	if authType != tls.NoClientCert && clientCAFile == "" {
+		return nil, fmt.Errorf("invalid configuration: %s.clientAuth %s requires clientCAFile", tlsPrefix, clientAuth)
+	}
+	config.ClientAuth = authType
+
+	if minVersion == "" {
+		minVersion = "1.2"
+	}
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid configuration: unsupported %s.minVersion %s", tlsPrefix, minVersion)
+	}
+	config.MinVersion = version
+
+	if len(cipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			suites[suite.Name] = suite.ID
+		}
+		for _, name := range cipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("invalid configuration: unsupported cipher suite %s in %s.cipherSuites", name, tlsPrefix)
+			}
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+
+	if reloadInterval == 0 {
+		config.ReloadInterval = 10 * time.Second
+	} else if reloadInterval < 0 {
+		return nil, fmt.Errorf("invalid configuration: %s.reloadInterval can't be less than 0", tlsPrefix)
+	}
+
+	return config, nil
+}
+
+// certReloader serves the certificate and client CA pool from disk, reloading
+// them during handshakes when the files' modification times change. Checks
+// are throttled to once per ReloadInterval.
+type certReloader struct {
+	name   string
+	config *TLSConfig
+
+	mu          sync.RWMutex
+	cert        *tls.Certificate
+	clientCAs   *x509.CertPool
+	modTimes    map[string]time.Time
+	lastChecked time.Time
+}
+
+func newCertReloader(name string, config *TLSConfig) (*certReloader, error) {
+	r := &certReloader{name: name, config: config, modTimes: make(map[string]time.Time)}
+	err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+func (r *certReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS key pair: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.config.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA file %s", r.config.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	r.lastChecked = time.Now()
+	r.mu.Unlock()
+
+	return nil
+}
+
+func (r *certReloader) maybeReload() {
+	r.mu.RLock()
+	due := time.Since(r.lastChecked) >= r.config.ReloadInterval
+	r.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	changed := false
+	r.mu.Lock()
+	r.lastChecked = time.Now()
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err == nil && !info.ModTime().Equal(r.modTimes[file]) {
+			changed = true
+		}
+	}
+	r.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	err := r.load()
+	if err != nil {
+		log.Error("Failed to reload GRPC TLS certificates, keeping previous ones", "name", r.name, "error", err)
+		return
+	}
+	log.Info("Reloaded GRPC TLS certificates", "name", r.name)
+}
+
+func (r *certReloader) serverConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion:   r.config.MinVersion,
+		CipherSuites: r.config.CipherSuites,
+		ClientAuth:   r.config.ClientAuth,
+		NextProtos:   []string{"h2"},
+	}
+
+	base.GetConfigForClient = func(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+		r.maybeReload()
+
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		config := base.Clone()
+		config.GetConfigForClient = nil
+		config.Certificates = []tls.Certificate{*r.cert}
+		config.ClientCAs = r.clientCAs
+		return config, nil
+	}
+
+	return base
+}
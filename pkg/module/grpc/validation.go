@@ -0,0 +1,148 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/bufbuild/protovalidate-go"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/viper"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type ValidationConfig struct {
+	Enabled  bool
+	FailFast bool
+}
+
+func parseValidationConfig(configPrefix string) ValidationConfig {
+	validationPrefix := fmt.Sprintf("%s.validation", configPrefix)
+
+	return ValidationConfig{
+		Enabled:  viper.GetBool(fmt.Sprintf("%s.enabled", validationPrefix)),
+		FailFast: viper.GetBool(fmt.Sprintf("%s.failFast", validationPrefix)),
+	}
+}
+
+// Validator is implemented by messages with hand-written or generated
+// (e.g. protoc-gen-validate) validation.
+type Validator interface {
+	Validate() error
+}
+
+// fieldError matches the errors generated by protoc-gen-validate.
+type fieldError interface {
+	Field() string
+	Reason() string
+}
+
+// multiError matches the aggregated errors generated by protoc-gen-validate.
+type multiError interface {
+	AllErrors() []error
+}
+
+type requestValidator struct {
+	name      string
+	validator *protovalidate.Validator
+}
+
+func newRequestValidator(name string, config ValidationConfig) (*requestValidator, error) {
+	validator, err := protovalidate.New(protovalidate.WithFailFast(config.FailFast))
+	if err != nil {
+		return nil, err
+	}
+	return &requestValidator{name: name, validator: validator}, nil
+}
+
+// validate checks constraints declared in the message's proto definition and
+// then calls its Validate method if there is one.
+func (v *requestValidator) validate(method string, msg interface{}) error {
+	if protoMsg, ok := msg.(proto.Message); ok {
+		err := v.validator.Validate(protoMsg)
+		var validationErr *protovalidate.ValidationError
+		if errors.As(err, &validationErr) {
+			violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(validationErr.Violations))
+			for _, violation := range validationErr.Violations {
+				violations = append(violations, &errdetails.BadRequest_FieldViolation{
+					Field:       violation.FieldPath,
+					Description: violation.Message,
+				})
+			}
+			return invalidArgument(violations)
+		} else if err != nil {
+			log.Error("Failed to evaluate GRPC request constraints", "name", v.name, "method", method, "error", err)
+			return status.Error(codes.Internal, "failed to validate request")
+		}
+	}
+
+	if validator, ok := msg.(Validator); ok {
+		err := validator.Validate()
+		if err != nil {
+			return invalidArgument(fieldViolations(err))
+		}
+	}
+
+	return nil
+}
+
+func fieldViolations(err error) []*errdetails.BadRequest_FieldViolation {
+	var multi multiError
+	if errors.As(err, &multi) {
+		var violations []*errdetails.BadRequest_FieldViolation
+		for _, e := range multi.AllErrors() {
+			violations = append(violations, fieldViolations(e)...)
+		}
+		return violations
+	}
+
+	var field fieldError
+	if errors.As(err, &field) {
+		return []*errdetails.BadRequest_FieldViolation{{Field: field.Field(), Description: field.Reason()}}
+	}
+
+	return []*errdetails.BadRequest_FieldViolation{{Description: err.Error()}}
+}
+
+func invalidArgument(violations []*errdetails.BadRequest_FieldViolation) error {
+	st := status.New(codes.InvalidArgument, "invalid request")
+	detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+func (v *requestValidator) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		err := v.validate(info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+type validatingServerStream struct {
+	grpc.ServerStream
+	validator *requestValidator
+	method    string
+}
+
+func (s *validatingServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+	return s.validator.validate(s.method, m)
+}
+
+func (v *requestValidator) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &validatingServerStream{ServerStream: ss, validator: v, method: info.FullMethod})
+	}
+}
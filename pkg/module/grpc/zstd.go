@@ -0,0 +1,108 @@
+package grpc
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+)
+
+const (
+	zstdName = "zstd"
+
+	// grpc-go's default limit for received messages.
+	defaultMaxRecvMsgSize = 4 * 1024 * 1024
+)
+
+// zstdMaxWindow caps the memory a decoder may use for a frame's window. The
+// compressor is shared by every server in the process, so each server raises
+// it to its own maximum receive size; grpc-go rejects larger messages anyway.
+var zstdMaxWindow atomic.Uint64
+
+func init() {
+	zstdMaxWindow.Store(defaultMaxRecvMsgSize)
+	encoding.RegisterCompressor(&zstdCompressor{})
+}
+
+func raiseZstdMaxWindow(size int) {
+	for {
+		current := zstdMaxWindow.Load()
+		if uint64(size) <= current || zstdMaxWindow.CompareAndSwap(current, uint64(size)) {
+			return
+		}
+	}
+}
+
+// zstdCompressor implements encoding.Compressor with pooled encoders and
+// decoders, following the gzip compressor shipped with grpc-go.
+type zstdCompressor struct {
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+type zstdWriter struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+func (c *zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	z, ok := c.encoders.Get().(*zstdWriter)
+	if !ok {
+		encoder, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &zstdWriter{Encoder: encoder, pool: &c.encoders}, nil
+	}
+	z.Encoder.Reset(w)
+	return z, nil
+}
+
+func (z *zstdWriter) Close() error {
+	defer z.pool.Put(z)
+	return z.Encoder.Close()
+}
+
+type zstdReader struct {
+	*zstd.Decoder
+	pool      *sync.Pool
+	maxWindow uint64
+}
+
+func (c *zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	maxWindow := zstdMaxWindow.Load()
+	// Decoders created before the limit was raised are dropped.
+	z, ok := c.decoders.Get().(*zstdReader)
+	if !ok || z.maxWindow != maxWindow {
+		window := min(max(maxWindow, zstd.MinWindowSize), zstd.MaxWindowSize)
+		decoder, err := zstd.NewReader(r,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderLowmem(true),
+			zstd.WithDecoderMaxMemory(maxWindow),
+			zstd.WithDecoderMaxWindow(window),
+		)
+		if err != nil {
+			return nil, err
+		}
+		return &zstdReader{Decoder: decoder, pool: &c.decoders, maxWindow: maxWindow}, nil
+	}
+	if err := z.Decoder.Reset(r); err != nil {
+		c.decoders.Put(z)
+		return nil, err
+	}
+	return z, nil
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+	n, err := z.Decoder.Read(p)
+	if err == io.EOF {
+		z.pool.Put(z)
+	}
+	return n, err
+}
+
+func (c *zstdCompressor) Name() string {
+	return zstdName
+}
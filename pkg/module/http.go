@@ -0,0 +1,12 @@
+package module
+
+import (
+	"net/http"
+)
+
+// HandlerDefinition is an HTTP handler mounted on an HTTP-based module's mux
+// in addition to its own endpoints.
+type HandlerDefinition struct {
+	Pattern string
+	Handler http.Handler
+}
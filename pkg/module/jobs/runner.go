@@ -0,0 +1,315 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+
+	"github.com/dnikishov/microboiler/pkg/module"
+)
+
+const (
+	jobResultSuccess = "success"
+	jobResultFailure = "failure"
+	jobResultPanic   = "panic"
+	jobResultDropped = "dropped"
+)
+
+var (
+	ErrQueueFull     = errors.New("job queue is full")
+	ErrRunnerStopped = errors.New("job runner is stopped")
+)
+
+type JobFunc = func(ctx context.Context) error
+
+type Config struct {
+	Workers      int
+	QueueSize    int
+	DrainTimeout time.Duration
+}
+
+type job struct {
+	name string
+	fn   JobFunc
+}
+
+type JobRunnerModule struct {
+	module.Base
+	config *Config
+
+	queue     chan *job
+	jobCtx    context.Context
+	jobCancel context.CancelFunc
+	workers   sync.WaitGroup
+
+	mu      sync.RWMutex
+	stopped bool
+	delayed map[*time.Timer]*job
+
+	Metrics *Metrics
+}
+
+type Metrics struct {
+	queueDepth  prometheus.GaugeFunc
+	delayedJobs prometheus.GaugeFunc
+	outcomes    *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+}
+
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.queueDepth.Describe(ch)
+	m.delayedJobs.Describe(ch)
+	m.outcomes.Describe(ch)
+	m.duration.Describe(ch)
+}
+
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.queueDepth.Collect(ch)
+	m.delayedJobs.Collect(ch)
+	m.outcomes.Collect(ch)
+	m.duration.Collect(ch)
+}
+
+func (p *JobRunnerModule) Configure() error {
+	configPrefix := fmt.Sprintf("jobs-%s", p.GetName())
+
+	workers := viper.GetInt(fmt.Sprintf("%s.workers", configPrefix))
+	queueSize := viper.GetInt(fmt.Sprintf("%s.queue_size", configPrefix))
+	drainTimeout := viper.GetDuration(fmt.Sprintf("%s.drain_timeout", configPrefix))
+
+	if workers == 0 {
+		workers = 4
+	} else if workers < 0 {
+		return fmt.Errorf("Invalid configuration: %s.workers must be greater than 0", configPrefix)
+	}
+
+	if queueSize == 0 {
+		queueSize = 1024
+	} else if queueSize < 0 {
+		return fmt.Errorf("Invalid configuration: %s.queue_size must be greater than 0", configPrefix)
+	}
+
+	if drainTimeout == 0 {
+		drainTimeout = 30 * time.Second
+	} else if drainTimeout < 0 {
+		return fmt.Errorf("Invalid configuration: %s.drain_timeout can't be less than 0", configPrefix)
+	}
+
+	p.config = &Config{
+		Workers:      workers,
+		QueueSize:    queueSize,
+		DrainTimeout: drainTimeout,
+	}
+
+	module.RegisterCollector(configPrefix, p.Metrics)
+
+	return nil
+}
+
+func (p *JobRunnerModule) Init(_ context.Context) error {
+	p.queue = make(chan *job, p.config.QueueSize)
+	p.jobCtx, p.jobCancel = context.WithCancel(context.Background())
+
+	log.Info("Job runner initialized", "name", p.GetName(), "workers", p.config.Workers, "queue_size", p.config.QueueSize)
+
+	return nil
+}
+
+func (p *JobRunnerModule) Main(ctx context.Context) error {
+	log.Info("Starting job runner workers", "name", p.GetName(), "workers", p.config.Workers)
+
+	for i := 0; i < p.config.Workers; i++ {
+		p.workers.Add(1)
+		go p.worker()
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+func (p *JobRunnerModule) Cleanup(_ context.Context) {
+	log.Info("Stopping job runner", "name", p.GetName())
+
+	p.mu.Lock()
+	p.stopped = true
+	for timer, j := range p.delayed {
+		if timer.Stop() {
+			log.Warn("Dropping delayed job on shutdown", "name", p.GetName(), "job", j.name)
+			p.Metrics.outcomes.WithLabelValues(j.name, jobResultDropped).Inc()
+		}
+	}
+	p.delayed = nil
+	close(p.queue)
+	p.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		log.Info("Job runner drained", "name", p.GetName())
+		p.jobCancel()
+	case <-time.After(p.config.DrainTimeout):
+		log.Warn("Job runner drain timed out, cancelling running jobs", "name", p.GetName(), "queued", len(p.queue))
+		p.jobCancel()
+		// Workers stop picking up jobs once the context is cancelled, so the
+		// rest of the queue is dropped here.
+		for j := range p.queue {
+			p.drop(j)
+		}
+	}
+}
+
+// Enqueue schedules fn to run as soon as a worker is available.
+func (p *JobRunnerModule) Enqueue(name string, fn JobFunc) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.stopped || p.queue == nil {
+		return ErrRunnerStopped
+	}
+
+	select {
+	case p.queue <- &job{name: name, fn: fn}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// EnqueueAfter schedules fn to be enqueued once delay has passed. Delayed jobs
+// that are not yet due when the runner stops are dropped.
+func (p *JobRunnerModule) EnqueueAfter(name string, fn JobFunc, delay time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped || p.queue == nil {
+		return ErrRunnerStopped
+	}
+
+	j := &job{name: name, fn: fn}
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		p.mu.Lock()
+		delete(p.delayed, timer)
+		p.mu.Unlock()
+
+		err := p.Enqueue(j.name, j.fn)
+		if err != nil {
+			log.Error("Failed to enqueue delayed job", "name", p.GetName(), "job", j.name, "error", err)
+			p.Metrics.outcomes.WithLabelValues(j.name, jobResultDropped).Inc()
+		}
+	})
+	p.delayed[timer] = j
+
+	return nil
+}
+
+func (p *JobRunnerModule) EnqueueAt(name string, fn JobFunc, at time.Time) error {
+	return p.EnqueueAfter(name, fn, time.Until(at))
+}
+
+func (p *JobRunnerModule) delayedCount() float64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return float64(len(p.delayed))
+}
+
+func (p *JobRunnerModule) worker() {
+	defer p.workers.Done()
+
+	for {
+		select {
+		case <-p.jobCtx.Done():
+			return
+		case j, ok := <-p.queue:
+			if !ok {
+				return
+			}
+			if p.jobCtx.Err() != nil {
+				p.drop(j)
+				return
+			}
+			p.run(j)
+		}
+	}
+}
+
+func (p *JobRunnerModule) drop(j *job) {
+	log.Warn("Dropping queued job on shutdown", "name", p.GetName(), "job", j.name)
+	p.Metrics.outcomes.WithLabelValues(j.name, jobResultDropped).Inc()
+}
+
+func (p *JobRunnerModule) run(j *job) {
+	start := time.Now()
+	result := jobResultSuccess
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("Job panicked", "name", p.GetName(), "job", j.name, "panic", r)
+			result = jobResultPanic
+		}
+		p.Metrics.outcomes.WithLabelValues(j.name, result).Inc()
+		p.Metrics.duration.WithLabelValues(j.name).Observe(time.Since(start).Seconds())
+	}()
+
+	err := j.fn(p.jobCtx)
+	if err != nil {
+		log.Error("Job failed", "name", p.GetName(), "job", j.name, "error", err)
+		result = jobResultFailure
+	}
+}
+
+func newMetrics(p *JobRunnerModule) *Metrics {
+	constLabels := prometheus.Labels{"app": p.GetName()}
+	return &Metrics{
+		queueDepth: prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name:        "job_runner_queue_depth",
+				Help:        "Number of jobs waiting for a worker.",
+				ConstLabels: constLabels,
+			},
+			func() float64 { return float64(len(p.queue)) },
+		),
+		delayedJobs: prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name:        "job_runner_delayed_jobs",
+				Help:        "Number of delayed jobs that are not yet due.",
+				ConstLabels: constLabels,
+			},
+			p.delayedCount,
+		),
+		outcomes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "job_runner_jobs_total",
+				Help:        "Total number of finished jobs, by outcome.",
+				ConstLabels: constLabels,
+			},
+			[]string{"job", "result"},
+		),
+		duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:        "job_runner_job_duration_seconds",
+				Help:        "Job execution time.",
+				ConstLabels: constLabels,
+				Buckets:     []float64{0.001, 0.01, 0.1, 0.3, 0.6, 1, 3, 6, 9, 20, 30, 60, 90, 120},
+			},
+			[]string{"job"},
+		),
+	}
+}
+
+func NewJobRunnerModule(name string) *JobRunnerModule {
+	p := &JobRunnerModule{Base: module.Base{Name: name, IncludesInit: true, IncludesMain: true, IncludesCleanup: true}, delayed: make(map[*time.Timer]*job)}
+	p.Metrics = newMetrics(p)
+	return p
+}
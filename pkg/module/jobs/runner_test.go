@@ -0,0 +1,94 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestRunner(t *testing.T, drainTimeout time.Duration) (*JobRunnerModule, context.CancelFunc) {
+	t.Helper()
+
+	p := NewJobRunnerModule("test")
+	p.config = &Config{Workers: 1, QueueSize: 10, DrainTimeout: drainTimeout}
+	err := p.Init(context.Background())
+	if err != nil {
+		t.Fatalf("failed to initialize job runner: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go p.Main(ctx)
+	return p, cancel
+}
+
+func TestJobRunnerCleanup(t *testing.T) {
+	tests := []struct {
+		name         string
+		drainTimeout time.Duration
+		want         map[string]float64
+	}{
+		{
+			name:         "drained",
+			drainTimeout: 5 * time.Second,
+			want:         map[string]float64{jobResultSuccess: 4},
+		},
+		{
+			name:         "drain timeout",
+			drainTimeout: 20 * time.Millisecond,
+			want:         map[string]float64{jobResultFailure: 1, jobResultDropped: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, cancel := newTestRunner(t, tt.drainTimeout)
+
+			started := make(chan struct{})
+			blocking := func(ctx context.Context) error {
+				close(started)
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(100 * time.Millisecond):
+					return nil
+				}
+			}
+			var ranAfterCleanup bool
+			cleanedUp := make(chan struct{})
+			quick := func(ctx context.Context) error {
+				select {
+				case <-cleanedUp:
+					ranAfterCleanup = true
+				default:
+				}
+				return ctx.Err()
+			}
+
+			if err := p.Enqueue("job", blocking); err != nil {
+				t.Fatalf("failed to enqueue job: %s", err)
+			}
+			<-started
+			for i := 0; i < 3; i++ {
+				if err := p.Enqueue("job", quick); err != nil {
+					t.Fatalf("failed to enqueue job: %s", err)
+				}
+			}
+
+			cancel()
+			p.Cleanup(context.Background())
+			close(cleanedUp)
+			p.workers.Wait()
+
+			if ranAfterCleanup {
+				t.Error("a job ran after Cleanup returned")
+			}
+			for _, result := range []string{jobResultSuccess, jobResultFailure, jobResultDropped} {
+				if got := testutil.ToFloat64(p.Metrics.outcomes.WithLabelValues("job", result)); got != tt.want[result] {
+					t.Errorf("%s jobs = %v, want %v", result, got, tt.want[result])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package listener
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/viper"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/dnikishov/microboiler/pkg/module"
+)
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]*SharedListenerModule)
+)
+
+// Lookup returns the shared listener module registered under name. Modules
+// that support sharing a port call it from Init when their configuration
+// names a shared listener.
+func Lookup(name string) (*SharedListenerModule, error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	p, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("shared listener %s does not exist", name)
+	}
+	return p, nil
+}
+
+type Config struct {
+	ListenAddress string
+	CertFile      string
+	KeyFile       string
+}
+
+// SharedListenerModule serves gRPC and plain HTTP handlers on one address.
+// HTTP/2 requests with an application/grpc content type go to the attached
+// gRPC server, everything else to the HTTP mux.
+type SharedListenerModule struct {
+	module.Base
+	config *Config
+
+	mu          sync.RWMutex
+	grpcHandler http.Handler
+	serveMux    *http.ServeMux
+	patterns    map[string]bool
+	server      *http.Server
+}
+
+func (p *SharedListenerModule) Configure() error {
+	configPrefix := fmt.Sprintf("listener-%s", p.GetName())
+
+	listenAddress := viper.GetString(fmt.Sprintf("%s.listen_address", configPrefix))
+	certFile := viper.GetString(fmt.Sprintf("%s.cert_file", configPrefix))
+	keyFile := viper.GetString(fmt.Sprintf("%s.key_file", configPrefix))
+
+	if listenAddress == "" {
+		return fmt.Errorf("Invalid configuration: %s.listen_address is not set", configPrefix)
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return fmt.Errorf("Invalid configuration: both %s.cert_file and %s.key_file must be set", configPrefix, configPrefix)
+	}
+
+	p.config = &Config{
+		ListenAddress: listenAddress,
+		CertFile:      certFile,
+		KeyFile:       keyFile,
+	}
+
+	return nil
+}
+
+func (p *SharedListenerModule) Main(_ context.Context) error {
+	log.Info("Starting shared listener", "name", p.GetName(), "address", p.config.ListenAddress)
+
+	var err error
+	if p.config.CertFile != "" {
+		p.server = &http.Server{Addr: p.config.ListenAddress, Handler: p}
+		err = http2.ConfigureServer(p.server, &http2.Server{})
+		if err != nil {
+			return err
+		}
+		err = p.server.ListenAndServeTLS(p.config.CertFile, p.config.KeyFile)
+	} else {
+		p.server = &http.Server{Addr: p.config.ListenAddress, Handler: h2c.NewHandler(p, &http2.Server{})}
+		err = p.server.ListenAndServe()
+	}
+
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	log.Info("Shared listener stopped", "name", p.GetName(), "address", p.config.ListenAddress)
+	return nil
+}
+
+func (p *SharedListenerModule) Cleanup(ctx context.Context) {
+	log.Info("Stopping shared listener", "name", p.GetName())
+	if p.server != nil {
+		p.server.Shutdown(ctx)
+	}
+}
+
+func (p *SharedListenerModule) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.RLock()
+	grpcHandler := p.grpcHandler
+	p.mu.RUnlock()
+
+	if grpcHandler != nil && r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		grpcHandler.ServeHTTP(w, r)
+		return
+	}
+
+	p.serveMux.ServeHTTP(w, r)
+}
+
+// Handle attaches an HTTP handler for pattern. Each pattern can only be
+// attached once, e.g. modules mounting the same extra handler must use
+// different paths for it.
+func (p *SharedListenerModule) Handle(pattern string, handler http.Handler) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.patterns[pattern] {
+		return fmt.Errorf("shared listener %s already has a handler for %s", p.GetName(), pattern)
+	}
+	log.Info("Attaching HTTP handler to shared listener", "name", p.GetName(), "pattern", pattern)
+	p.serveMux.Handle(pattern, handler)
+	p.patterns[pattern] = true
+	return nil
+}
+
+func (p *SharedListenerModule) HandleGRPC(handler http.Handler) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.grpcHandler != nil {
+		return fmt.Errorf("shared listener %s already has a GRPC server attached", p.GetName())
+	}
+	log.Info("Attaching GRPC server to shared listener", "name", p.GetName())
+	p.grpcHandler = handler
+	return nil
+}
+
+func NewSharedListenerModule(name string) *SharedListenerModule {
+	p := &SharedListenerModule{Base: module.Base{Name: name, IncludesMain: true, IncludesCleanup: true}, serveMux: http.NewServeMux(), patterns: make(map[string]bool)}
+
+	registryMu.Lock()
+	registry[name] = p
+	registryMu.Unlock()
+
+	return p
+}
@@ -0,0 +1,21 @@
+package listener
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHandleRejectsDuplicatePatterns(t *testing.T) {
+	p := NewSharedListenerModule("shared-test")
+	handler := http.NotFoundHandler()
+
+	if err := p.Handle("/admin/", handler); err != nil {
+		t.Fatalf("first handler: %s", err)
+	}
+	if err := p.Handle("/metrics", handler); err != nil {
+		t.Fatalf("other pattern: %s", err)
+	}
+	if err := p.Handle("/admin/", handler); err == nil {
+		t.Fatal("duplicate pattern was accepted")
+	}
+}
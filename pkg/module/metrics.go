@@ -0,0 +1,93 @@
+package module
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	taskResultSuccess = "success"
+	taskResultFailure = "failure"
+)
+
+// TaskMetrics collects periodic task metrics for every task in the process.
+var TaskMetrics = newTaskMetricsCollector()
+
+type TaskMetricsCollector struct {
+	runs     *prometheus.CounterVec
+	attempts *prometheus.CounterVec
+}
+
+func (m *TaskMetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	m.runs.Describe(ch)
+	m.attempts.Describe(ch)
+}
+
+func (m *TaskMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	m.runs.Collect(ch)
+	m.attempts.Collect(ch)
+}
+
+func newTaskMetricsCollector() *TaskMetricsCollector {
+	return &TaskMetricsCollector{
+		runs: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "periodic_task_runs_total",
+				Help: "Total number of scheduled periodic task runs, by result.",
+			},
+			[]string{"task", "result"},
+		),
+		attempts: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "periodic_task_attempts_total",
+				Help: "Total number of periodic task attempts including retries, by result.",
+			},
+			[]string{"task", "result"},
+		),
+	}
+}
+
+type SharedCollector struct {
+	Name      string
+	Collector prometheus.Collector
+}
+
+// Task metrics are shared by every module with periodic tasks, so they are
+// registered once for the whole process.
+var sharedCollectors = struct {
+	mu         sync.Mutex
+	collectors []SharedCollector
+}{collectors: []SharedCollector{{Name: "periodic-tasks", Collector: TaskMetrics}}}
+
+// RegisterCollector adds collector to the set exported by every Prometheus
+// exporter module in the process. Modules call it from Configure so that the
+// collectors are known before exporters are initialized.
+func RegisterCollector(name string, collector prometheus.Collector) {
+	sharedCollectors.mu.Lock()
+	defer sharedCollectors.mu.Unlock()
+	sharedCollectors.collectors = append(sharedCollectors.collectors, SharedCollector{Name: name, Collector: collector})
+}
+
+// UnregisterCollector removes the collectors added under name, e.g. when a
+// test tears down the modules it configured. Exporters that were already
+// initialized keep exporting them.
+func UnregisterCollector(name string) {
+	sharedCollectors.mu.Lock()
+	defer sharedCollectors.mu.Unlock()
+
+	kept := sharedCollectors.collectors[:0]
+	for _, collector := range sharedCollectors.collectors {
+		if collector.Name != name {
+			kept = append(kept, collector)
+		}
+	}
+	sharedCollectors.collectors = kept
+}
+
+// SharedCollectors returns the collectors added with RegisterCollector.
+func SharedCollectors() []SharedCollector {
+	sharedCollectors.mu.Lock()
+	defer sharedCollectors.mu.Unlock()
+	return append([]SharedCollector(nil), sharedCollectors.collectors...)
+}
@@ -8,11 +8,13 @@ import (
 
 	"github.com/charmbracelet/log"
 	"github.com/dnikishov/microboiler/pkg/module"
+	"github.com/dnikishov/microboiler/pkg/module/listener"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	ListenAddress string
+	ListenAddress  string
+	SharedListener string
 }
 
 type PprofModule struct {
@@ -21,6 +23,7 @@ type PprofModule struct {
 
 	server   *http.Server
 	serveMux *http.ServeMux
+	handlers []module.HandlerDefinition
 }
 
 func (p *PprofModule) Init(_ context.Context) error {
@@ -31,10 +34,38 @@ func (p *PprofModule) Init(_ context.Context) error {
 	p.serveMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 	p.serveMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
 
+	for _, h := range p.handlers {
+		log.Info("Mounting HTTP handler", "name", p.GetName(), "pattern", h.Pattern)
+		p.serveMux.Handle(h.Pattern, h.Handler)
+	}
+
+	if p.config.SharedListener != "" {
+		shared, err := listener.Lookup(p.config.SharedListener)
+		if err != nil {
+			return err
+		}
+		err = shared.Handle("/debug/pprof/", p.serveMux)
+		if err != nil {
+			return err
+		}
+		for _, h := range p.handlers {
+			err = shared.Handle(h.Pattern, p.serveMux)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
-func (p *PprofModule) Main(_ context.Context) error {
+func (p *PprofModule) Main(ctx context.Context) error {
+	if p.config.SharedListener != "" {
+		log.Info("Pprof server is served by shared listener", "name", p.GetName(), "listener", p.config.SharedListener)
+		<-ctx.Done()
+		return nil
+	}
+
 	log.Info("Starting pprof server", "name", p.GetName(), "address", p.config.ListenAddress)
 	p.server = &http.Server{Addr: p.config.ListenAddress, Handler: p.serveMux}
 	err := p.server.ListenAndServe()
@@ -49,13 +80,15 @@ func (p *PprofModule) Configure() error {
 	configPrefix := fmt.Sprintf("pprof-%s", p.GetName())
 
 	listenAddress := viper.GetString(fmt.Sprintf("%s.listen_address", configPrefix))
+	sharedListener := viper.GetString(fmt.Sprintf("%s.shared_listener", configPrefix))
 
 	if listenAddress == "" {
 		listenAddress = "localhost:8080"
 	}
 
 	p.config = &Config{
-		ListenAddress: listenAddress,
+		ListenAddress:  listenAddress,
+		SharedListener: sharedListener,
 	}
 
 	return nil
@@ -63,7 +96,15 @@ func (p *PprofModule) Configure() error {
 
 func (p *PprofModule) Cleanup(ctx context.Context) {
 	log.Info("Stopping pprof server", "name", p.GetName())
-	p.server.Shutdown(ctx)
+	if p.server != nil {
+		p.server.Shutdown(ctx)
+	}
+}
+
+// Handle mounts an additional handler on the module's HTTP server. It must be
+// called before the module is initialized.
+func (p *PprofModule) Handle(pattern string, handler http.Handler) {
+	p.handlers = append(p.handlers, module.HandlerDefinition{Pattern: pattern, Handler: handler})
 }
 
 func NewPprofModule(name string) *PprofModule {
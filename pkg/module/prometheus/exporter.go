@@ -2,6 +2,7 @@ package prometheus
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/charmbracelet/log"
 
 	"github.com/dnikishov/microboiler/pkg/module"
+	"github.com/dnikishov/microboiler/pkg/module/listener"
 )
 
 type CollectorDefinition struct {
@@ -21,9 +23,10 @@ type CollectorDefinition struct {
 }
 
 type Config struct {
-	MetricsPath   string
-	ListenAddress string
-	MaxRequests   int
+	MetricsPath    string
+	ListenAddress  string
+	MaxRequests    int
+	SharedListener string
 }
 
 type Options struct {
@@ -39,6 +42,7 @@ type PrometheusExporterModule struct {
 	registry *prometheus.Registry
 	serveMux *http.ServeMux
 	server   *http.Server
+	handlers []module.HandlerDefinition
 }
 
 func (p *PrometheusExporterModule) Init(_ context.Context) error {
@@ -49,6 +53,19 @@ func (p *PrometheusExporterModule) Init(_ context.Context) error {
 		p.registry.MustRegister(def.Collector)
 	}
 
+	// Shared collectors may also be listed in CollectorDefinitions by
+	// applications written before modules registered them automatically.
+	for _, def := range module.SharedCollectors() {
+		log.Info("Registering shared collector", "name", p.GetName(), "collector_name", def.Name)
+		err := p.registry.Register(def.Collector)
+		var alreadyRegistered prometheus.AlreadyRegisteredError
+		if errors.As(err, &alreadyRegistered) {
+			log.Info("Shared collector is already registered, skipping", "name", p.GetName(), "collector_name", def.Name)
+		} else if err != nil {
+			return fmt.Errorf("failed to register collector %s: %w", def.Name, err)
+		}
+	}
+
 	handler := promhttp.HandlerFor(
 		prometheus.Gatherers{p.registry},
 		promhttp.HandlerOpts{
@@ -61,6 +78,11 @@ func (p *PrometheusExporterModule) Init(_ context.Context) error {
 	p.serveMux = http.NewServeMux()
 	p.serveMux.Handle(p.config.MetricsPath, handler)
 
+	for _, h := range p.handlers {
+		log.Info("Mounting HTTP handler", "name", p.GetName(), "pattern", h.Pattern)
+		p.serveMux.Handle(h.Pattern, h.Handler)
+	}
+
 	indexBody := fmt.Sprintf(
 		`<html>
 		<head><title>%s</title></head>
@@ -74,10 +96,35 @@ func (p *PrometheusExporterModule) Init(_ context.Context) error {
 		w.Write([]byte(indexBody))
 	})
 
+	// The index page is not attached to a shared listener, it would shadow
+	// every other handler there.
+	if p.config.SharedListener != "" {
+		shared, err := listener.Lookup(p.config.SharedListener)
+		if err != nil {
+			return err
+		}
+		err = shared.Handle(p.config.MetricsPath, p.serveMux)
+		if err != nil {
+			return err
+		}
+		for _, h := range p.handlers {
+			err = shared.Handle(h.Pattern, p.serveMux)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
-func (p *PrometheusExporterModule) Main(_ context.Context) error {
+func (p *PrometheusExporterModule) Main(ctx context.Context) error {
+	if p.config.SharedListener != "" {
+		log.Info("Prometheus exporter is served by shared listener", "name", p.GetName(), "listener", p.config.SharedListener)
+		<-ctx.Done()
+		return nil
+	}
+
 	log.Info("Starting prometheus exporter", "name", p.GetName(), "address", p.config.ListenAddress)
 	p.server = &http.Server{Addr: p.config.ListenAddress, Handler: p.serveMux}
 	err := p.server.ListenAndServe()
@@ -94,6 +141,7 @@ func (p *PrometheusExporterModule) Configure() error {
 	metricsPath := viper.GetString(fmt.Sprintf("%s.metrics_path", configPrefix))
 	listenAddress := viper.GetString(fmt.Sprintf("%s.listen_address", configPrefix))
 	maxRequests := viper.GetInt(fmt.Sprintf("%s.max_requests", configPrefix))
+	sharedListener := viper.GetString(fmt.Sprintf("%s.shared_listener", configPrefix))
 
 	if metricsPath == "" {
 		metricsPath = "/metrics"
@@ -110,9 +158,10 @@ func (p *PrometheusExporterModule) Configure() error {
 	}
 
 	p.config = &Config{
-		MetricsPath:   metricsPath,
-		ListenAddress: listenAddress,
-		MaxRequests:   maxRequests,
+		MetricsPath:    metricsPath,
+		ListenAddress:  listenAddress,
+		MaxRequests:    maxRequests,
+		SharedListener: sharedListener,
 	}
 
 	return nil
@@ -120,7 +169,15 @@ func (p *PrometheusExporterModule) Configure() error {
 
 func (p *PrometheusExporterModule) Cleanup(ctx context.Context) {
 	log.Info("Stopping prometheus exporter", "name", p.GetName())
-	p.server.Shutdown(ctx)
+	if p.server != nil {
+		p.server.Shutdown(ctx)
+	}
+}
+
+// Handle mounts an additional handler on the module's HTTP server. It must be
+// called before the module is initialized.
+func (p *PrometheusExporterModule) Handle(pattern string, handler http.Handler) {
+	p.handlers = append(p.handlers, module.HandlerDefinition{Pattern: pattern, Handler: handler})
 }
 
 func NewPrometheusExporterModule(name string, options *Options) *PrometheusExporterModule {
@@ -0,0 +1,506 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/dnikishov/microboiler/pkg/module"
+	db "github.com/dnikishov/microboiler/pkg/module/gorm"
+)
+
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusDead    = "dead"
+)
+
+const (
+	jobResultSuccess    = "success"
+	jobResultRetry      = "retry"
+	jobResultDeadLetter = "dead_letter"
+)
+
+var ErrUnknownHandler = errors.New("no handler registered for job")
+
+type HandlerFunc = func(ctx context.Context, job *Job) error
+
+type Job struct {
+	ID          uint64    `gorm:"primaryKey"`
+	Queue       string    `gorm:"size:64;not null;index:idx_microboiler_jobs_lease,priority:1"`
+	Handler     string    `gorm:"size:255;not null"`
+	Payload     []byte    `gorm:"type:mediumblob"`
+	Status      string    `gorm:"size:16;not null;index:idx_microboiler_jobs_lease,priority:2"`
+	Attempts    int       `gorm:"not null"`
+	MaxAttempts int       `gorm:"not null"`
+	RunAt       time.Time `gorm:"not null;index:idx_microboiler_jobs_lease,priority:3"`
+	LockedUntil *time.Time
+	LockedBy    string `gorm:"size:255"`
+	LastError   string `gorm:"type:text"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (Job) TableName() string {
+	return "microboiler_jobs"
+}
+
+type Config struct {
+	Workers       int
+	BatchSize     int
+	PollInterval  time.Duration
+	LeaseDuration time.Duration
+	MaxAttempts   int
+	DrainTimeout  time.Duration
+	Retry         module.RetryConfig
+}
+
+type Options struct {
+	Handlers map[string]HandlerFunc
+}
+
+type EnqueueOptions struct {
+	Delay       time.Duration
+	MaxAttempts int
+}
+
+// gormDatabase is the part of db.GORMDatabaseModule the queue relies on.
+type gormDatabase interface {
+	GetName() string
+	GetDB() *gorm.DB
+}
+
+type PersistentQueueModule struct {
+	module.Base
+	database gormDatabase
+	options  *Options
+	config   *Config
+	identity string
+
+	slots     chan struct{}
+	inFlight  sync.WaitGroup
+	mainDone  chan struct{}
+	jobCtx    context.Context
+	jobCancel context.CancelFunc
+
+	Metrics *Metrics
+}
+
+type Metrics struct {
+	leased   prometheus.Counter
+	outcomes *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.leased.Describe(ch)
+	m.outcomes.Describe(ch)
+	m.duration.Describe(ch)
+}
+
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.leased.Collect(ch)
+	m.outcomes.Collect(ch)
+	m.duration.Collect(ch)
+}
+
+func (p *PersistentQueueModule) Configure() error {
+	configPrefix := fmt.Sprintf("queue-%s", p.GetName())
+
+	workers := viper.GetInt(fmt.Sprintf("%s.workers", configPrefix))
+	batchSize := viper.GetInt(fmt.Sprintf("%s.batch_size", configPrefix))
+	pollInterval := viper.GetDuration(fmt.Sprintf("%s.poll_interval", configPrefix))
+	leaseDuration := viper.GetDuration(fmt.Sprintf("%s.lease_duration", configPrefix))
+	maxAttempts := viper.GetInt(fmt.Sprintf("%s.max_attempts", configPrefix))
+	drainTimeout := viper.GetDuration(fmt.Sprintf("%s.drain_timeout", configPrefix))
+	initialBackoff := viper.GetDuration(fmt.Sprintf("%s.backoff.initial", configPrefix))
+	maxBackoff := viper.GetDuration(fmt.Sprintf("%s.backoff.max", configPrefix))
+	jitter := viper.GetFloat64(fmt.Sprintf("%s.backoff.jitter", configPrefix))
+
+	if workers == 0 {
+		workers = 4
+	} else if workers < 0 {
+		return fmt.Errorf("Invalid configuration: %s.workers must be greater than 0", configPrefix)
+	}
+
+	if batchSize == 0 {
+		batchSize = workers
+	} else if batchSize < 0 {
+		return fmt.Errorf("Invalid configuration: %s.batch_size must be greater than 0", configPrefix)
+	}
+
+	if pollInterval == 0 {
+		pollInterval = time.Second
+	} else if pollInterval < 0 {
+		return fmt.Errorf("Invalid configuration: %s.poll_interval can't be less than 0", configPrefix)
+	}
+
+	if leaseDuration == 0 {
+		leaseDuration = 5 * time.Minute
+	} else if leaseDuration < 0 {
+		return fmt.Errorf("Invalid configuration: %s.lease_duration can't be less than 0", configPrefix)
+	}
+
+	if maxAttempts == 0 {
+		maxAttempts = 10
+	} else if maxAttempts < 0 {
+		return fmt.Errorf("Invalid configuration: %s.max_attempts must be greater than 0", configPrefix)
+	}
+
+	if drainTimeout == 0 {
+		drainTimeout = 30 * time.Second
+	} else if drainTimeout < 0 {
+		return fmt.Errorf("Invalid configuration: %s.drain_timeout can't be less than 0", configPrefix)
+	}
+
+	if initialBackoff == 0 {
+		initialBackoff = 10 * time.Second
+	}
+
+	if maxBackoff == 0 {
+		maxBackoff = time.Hour
+	}
+
+	if initialBackoff < 0 || maxBackoff < 0 || maxBackoff < initialBackoff {
+		return fmt.Errorf("Invalid configuration: %s.backoff must have 0 < initial <= max", configPrefix)
+	}
+
+	if jitter < 0 || jitter > 1 {
+		return fmt.Errorf("Invalid configuration: %s.backoff.jitter must be between 0 and 1", configPrefix)
+	}
+
+	p.config = &Config{
+		Workers:       workers,
+		BatchSize:     batchSize,
+		PollInterval:  pollInterval,
+		LeaseDuration: leaseDuration,
+		MaxAttempts:   maxAttempts,
+		DrainTimeout:  drainTimeout,
+		Retry: module.RetryConfig{
+			InitialBackoff: initialBackoff,
+			MaxBackoff:     maxBackoff,
+			Jitter:         jitter,
+		},
+	}
+
+	module.RegisterCollector(configPrefix, p.Metrics)
+
+	return nil
+}
+
+func (p *PersistentQueueModule) Init(_ context.Context) error {
+	gormDB := p.database.GetDB()
+	if gormDB == nil {
+		return fmt.Errorf("GORM database module %s is not initialized", p.database.GetName())
+	}
+
+	err := gormDB.AutoMigrate(&Job{})
+	if err != nil {
+		return fmt.Errorf("Failed to migrate job queue table: %s", err)
+	}
+
+	p.slots = make(chan struct{}, p.config.Workers)
+	p.mainDone = make(chan struct{})
+	p.jobCtx, p.jobCancel = context.WithCancel(context.Background())
+
+	log.Info("Persistent job queue initialized", "name", p.GetName(), "workers", p.config.Workers, "identity", p.identity)
+
+	return nil
+}
+
+func (p *PersistentQueueModule) Main(ctx context.Context) error {
+	defer close(p.mainDone)
+	log.Info("Starting persistent job queue", "name", p.GetName())
+
+	ticker := time.NewTicker(p.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		p.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Info("Persistent job queue stopped leasing jobs", "name", p.GetName())
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *PersistentQueueModule) Cleanup(_ context.Context) {
+	log.Info("Stopping persistent job queue", "name", p.GetName())
+
+	// Main may still be dispatching jobs it has just leased, so wait for it
+	// to stop before waiting for the jobs.
+	drained := make(chan struct{})
+	go func() {
+		<-p.mainDone
+		p.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		log.Info("Persistent job queue drained", "name", p.GetName())
+	case <-time.After(p.config.DrainTimeout):
+		log.Warn("Persistent job queue drain timed out, leased jobs will be retried after lease expiry", "name", p.GetName())
+	}
+
+	p.jobCancel()
+}
+
+// Enqueue stores a job using the module's database handle.
+func (p *PersistentQueueModule) Enqueue(ctx context.Context, handler string, payload []byte, opts *EnqueueOptions) (*Job, error) {
+	return p.EnqueueTx(p.database.GetDB().WithContext(ctx), handler, payload, opts)
+}
+
+// EnqueueTx stores a job using tx, so the job is only visible to workers once
+// the caller's transaction commits.
+func (p *PersistentQueueModule) EnqueueTx(tx *gorm.DB, handler string, payload []byte, opts *EnqueueOptions) (*Job, error) {
+	if _, ok := p.options.Handlers[handler]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownHandler, handler)
+	}
+
+	maxAttempts := p.config.MaxAttempts
+	runAt := time.Now()
+	if opts != nil {
+		if opts.MaxAttempts > 0 {
+			maxAttempts = opts.MaxAttempts
+		}
+		runAt = runAt.Add(opts.Delay)
+	}
+
+	job := &Job{
+		Queue:       p.GetName(),
+		Handler:     handler,
+		Payload:     payload,
+		Status:      StatusPending,
+		MaxAttempts: maxAttempts,
+		RunAt:       runAt,
+	}
+
+	err := tx.Create(job).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+func (p *PersistentQueueModule) poll(ctx context.Context) {
+	free := p.config.Workers - len(p.slots)
+	if free <= 0 {
+		return
+	}
+	if free > p.config.BatchSize {
+		free = p.config.BatchSize
+	}
+
+	jobs, err := p.lease(ctx, free)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Error("Failed to lease jobs", "name", p.GetName(), "error", err)
+		}
+		return
+	}
+
+	for i := range jobs {
+		job := jobs[i]
+		p.slots <- struct{}{}
+		p.inFlight.Add(1)
+		go func() {
+			defer func() {
+				<-p.slots
+				p.inFlight.Done()
+			}()
+			p.process(job)
+		}()
+	}
+}
+
+func (p *PersistentQueueModule) lease(ctx context.Context, limit int) ([]*Job, error) {
+	var jobs, dead []*Job
+	now := time.Now()
+	lockedUntil := now.Add(p.config.LeaseDuration)
+
+	err := p.database.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		dead, err = p.deadLetterExpired(tx, now)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("queue = ?", p.GetName()).
+			Where("(status = ? AND run_at <= ?) OR (status = ? AND locked_until < ? AND attempts < max_attempts)", StatusPending, now, StatusRunning, now).
+			Order("run_at").
+			Limit(limit).
+			Find(&jobs).Error
+		if err != nil || len(jobs) == 0 {
+			return err
+		}
+
+		ids := make([]uint64, 0, len(jobs))
+		for _, job := range jobs {
+			ids = append(ids, job.ID)
+			job.Status = StatusRunning
+			job.Attempts++
+			job.LockedUntil = &lockedUntil
+			job.LockedBy = p.identity
+		}
+
+		return tx.Model(&Job{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":       StatusRunning,
+			"attempts":     gorm.Expr("attempts + 1"),
+			"locked_until": lockedUntil,
+			"locked_by":    p.identity,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, job := range dead {
+		p.Metrics.outcomes.WithLabelValues(job.Handler, jobResultDeadLetter).Inc()
+	}
+	p.Metrics.leased.Add(float64(len(jobs)))
+	return jobs, nil
+}
+
+// deadLetterExpired moves jobs whose lease expired on their last attempt to
+// dead letter. Such jobs most likely crashed or hung their worker, so they are
+// not retried past their max attempts.
+func (p *PersistentQueueModule) deadLetterExpired(tx *gorm.DB, now time.Time) ([]*Job, error) {
+	var jobs []*Job
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("queue = ? AND status = ? AND locked_until < ? AND attempts >= max_attempts", p.GetName(), StatusRunning, now).
+		Find(&jobs).Error
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+
+	ids := make([]uint64, 0, len(jobs))
+	for _, job := range jobs {
+		log.Error("Job lease expired on last attempt, moved to dead letter", "name", p.GetName(), "job_id", job.ID, "handler", job.Handler, "attempts", job.Attempts, "locked_by", job.LockedBy)
+		ids = append(ids, job.ID)
+	}
+
+	err = tx.Model(&Job{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+		"status":       StatusDead,
+		"locked_until": nil,
+		"locked_by":    "",
+		"last_error":   "lease expired on last attempt",
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+func (p *PersistentQueueModule) process(job *Job) {
+	start := time.Now()
+	handler := p.options.Handlers[job.Handler]
+
+	var err error
+	if handler == nil {
+		err = module.Permanent(fmt.Errorf("%w: %s", ErrUnknownHandler, job.Handler))
+	} else {
+		err = p.callHandler(handler, job)
+	}
+	p.Metrics.duration.WithLabelValues(job.Handler).Observe(time.Since(start).Seconds())
+
+	updates := map[string]interface{}{"locked_until": nil, "locked_by": ""}
+	result := jobResultSuccess
+
+	switch {
+	case err == nil:
+		updates["status"] = StatusDone
+		updates["last_error"] = ""
+	case job.Attempts >= job.MaxAttempts || !p.config.Retry.IsRetryable(err):
+		log.Error("Job moved to dead letter", "name", p.GetName(), "job_id", job.ID, "handler", job.Handler, "attempts", job.Attempts, "error", err)
+		updates["status"] = StatusDead
+		updates["last_error"] = err.Error()
+		result = jobResultDeadLetter
+	default:
+		backoff := p.config.Retry.Backoff(job.Attempts)
+		log.Warn("Job failed, scheduling retry", "name", p.GetName(), "job_id", job.ID, "handler", job.Handler, "attempts", job.Attempts, "backoff", backoff, "error", err)
+		updates["status"] = StatusPending
+		updates["run_at"] = time.Now().Add(backoff)
+		updates["last_error"] = err.Error()
+		result = jobResultRetry
+	}
+
+	p.Metrics.outcomes.WithLabelValues(job.Handler, result).Inc()
+
+	// Only release the job if we still own the lease; otherwise another
+	// worker has already picked it up after expiry.
+	err = p.database.GetDB().Model(&Job{}).
+		Where("id = ? AND locked_by = ? AND attempts = ?", job.ID, p.identity, job.Attempts).
+		Updates(updates).Error
+	if err != nil {
+		log.Error("Failed to update job status", "name", p.GetName(), "job_id", job.ID, "error", err)
+	}
+}
+
+func (p *PersistentQueueModule) callHandler(handler HandlerFunc, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job handler panicked: %v", r)
+		}
+	}()
+
+	ctx, cancel := context.WithDeadline(p.jobCtx, *job.LockedUntil)
+	defer cancel()
+
+	return handler(ctx, job)
+}
+
+func newMetrics(name string) *Metrics {
+	constLabels := prometheus.Labels{"app": name}
+	return &Metrics{
+		leased: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name:        "job_queue_leased_total",
+				Help:        "Total number of jobs leased from the persistent queue.",
+				ConstLabels: constLabels,
+			},
+		),
+		outcomes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "job_queue_jobs_total",
+				Help:        "Total number of processed persistent jobs, by outcome.",
+				ConstLabels: constLabels,
+			},
+			[]string{"handler", "result"},
+		),
+		duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:        "job_queue_job_duration_seconds",
+				Help:        "Persistent job handler execution time.",
+				ConstLabels: constLabels,
+				Buckets:     []float64{0.001, 0.01, 0.1, 0.3, 0.6, 1, 3, 6, 9, 20, 30, 60, 90, 120},
+			},
+			[]string{"handler"},
+		),
+	}
+}
+
+func NewPersistentQueueModule(name string, database *db.GORMDatabaseModule, options *Options) *PersistentQueueModule {
+	return &PersistentQueueModule{
+		Base:     module.Base{Name: name, IncludesInit: true, IncludesMain: true, IncludesCleanup: true},
+		database: database,
+		options:  options,
+		identity: module.InstanceIdentity(),
+		Metrics:  newMetrics(name),
+	}
+}
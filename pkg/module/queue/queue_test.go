@@ -0,0 +1,309 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/dnikishov/microboiler/pkg/module"
+)
+
+const testHandler = "test"
+
+type testDatabase struct {
+	db *gorm.DB
+}
+
+func (d *testDatabase) GetName() string { return "test" }
+func (d *testDatabase) GetDB() *gorm.DB { return d.db }
+
+func newTestQueue(t *testing.T, handler HandlerFunc) *PersistentQueueModule {
+	t.Helper()
+
+	gormDB, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "queue.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+
+	p := &PersistentQueueModule{
+		Base:     module.Base{Name: "test"},
+		database: &testDatabase{db: gormDB},
+		options:  &Options{Handlers: map[string]HandlerFunc{testHandler: handler}},
+		config: &Config{
+			Workers:       1,
+			BatchSize:     10,
+			LeaseDuration: time.Minute,
+			MaxAttempts:   3,
+			Retry:         module.RetryConfig{InitialBackoff: time.Minute, MaxBackoff: time.Hour},
+		},
+		identity: "worker-1",
+		Metrics:  newMetrics("test"),
+	}
+
+	err = p.Init(context.Background())
+	if err != nil {
+		t.Fatalf("failed to initialize queue: %s", err)
+	}
+	t.Cleanup(p.jobCancel)
+
+	return p
+}
+
+func loadJob(t *testing.T, p *PersistentQueueModule, id uint64) *Job {
+	t.Helper()
+
+	var job Job
+	err := p.database.GetDB().First(&job, id).Error
+	if err != nil {
+		t.Fatalf("failed to load job %d: %s", id, err)
+	}
+	return &job
+}
+
+func TestPersistentQueueLease(t *testing.T) {
+	now := time.Now()
+	expired := now.Add(-time.Minute)
+	leased := now.Add(time.Minute)
+
+	tests := []struct {
+		name         string
+		job          Job
+		wantLeased   bool
+		wantStatus   string
+		wantAttempts int
+		wantDead     float64
+	}{
+		{
+			name:         "due pending job",
+			job:          Job{Status: StatusPending, MaxAttempts: 3, RunAt: now.Add(-time.Second)},
+			wantLeased:   true,
+			wantStatus:   StatusRunning,
+			wantAttempts: 1,
+		},
+		{
+			name:       "delayed pending job",
+			job:        Job{Status: StatusPending, MaxAttempts: 3, RunAt: now.Add(time.Hour)},
+			wantStatus: StatusPending,
+		},
+		{
+			name:         "expired lease is retried",
+			job:          Job{Status: StatusRunning, Attempts: 2, MaxAttempts: 3, RunAt: expired, LockedUntil: &expired, LockedBy: "worker-2"},
+			wantLeased:   true,
+			wantStatus:   StatusRunning,
+			wantAttempts: 3,
+		},
+		{
+			name:         "active lease",
+			job:          Job{Status: StatusRunning, Attempts: 1, MaxAttempts: 3, RunAt: expired, LockedUntil: &leased, LockedBy: "worker-2"},
+			wantStatus:   StatusRunning,
+			wantAttempts: 1,
+		},
+		{
+			name:         "expired lease on last attempt is dead-lettered",
+			job:          Job{Status: StatusRunning, Attempts: 3, MaxAttempts: 3, RunAt: expired, LockedUntil: &expired, LockedBy: "worker-2"},
+			wantStatus:   StatusDead,
+			wantAttempts: 3,
+			wantDead:     1,
+		},
+		{
+			name:         "finished job",
+			job:          Job{Status: StatusDone, Attempts: 1, MaxAttempts: 3, RunAt: expired},
+			wantStatus:   StatusDone,
+			wantAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestQueue(t, func(context.Context, *Job) error { return nil })
+
+			job := tt.job
+			job.Queue = p.GetName()
+			job.Handler = testHandler
+			err := p.database.GetDB().Create(&job).Error
+			if err != nil {
+				t.Fatalf("failed to create job: %s", err)
+			}
+			// A job of another queue must never be touched.
+			other := tt.job
+			other.Queue = "other"
+			other.Handler = testHandler
+			err = p.database.GetDB().Create(&other).Error
+			if err != nil {
+				t.Fatalf("failed to create job: %s", err)
+			}
+
+			jobs, err := p.lease(context.Background(), 10)
+			if err != nil {
+				t.Fatalf("lease() error = %s", err)
+			}
+			wantLeased := 0
+			if tt.wantLeased {
+				wantLeased = 1
+			}
+			if len(jobs) != wantLeased || (wantLeased == 1 && jobs[0].ID != job.ID) {
+				t.Fatalf("lease() returned %d jobs, want %d", len(jobs), wantLeased)
+			}
+
+			stored := loadJob(t, p, job.ID)
+			if stored.Status != tt.wantStatus || stored.Attempts != tt.wantAttempts {
+				t.Errorf("job = %s after %d attempts, want %s after %d", stored.Status, stored.Attempts, tt.wantStatus, tt.wantAttempts)
+			}
+			if tt.wantLeased && (stored.LockedBy != p.identity || stored.LockedUntil == nil || !stored.LockedUntil.After(now)) {
+				t.Errorf("job is locked by %q until %v, want a lease held by %q", stored.LockedBy, stored.LockedUntil, p.identity)
+			}
+			if otherStored := loadJob(t, p, other.ID); otherStored.Status != other.Status || otherStored.Attempts != other.Attempts {
+				t.Errorf("job of another queue was changed to %s after %d attempts", otherStored.Status, otherStored.Attempts)
+			}
+
+			if got := testutil.ToFloat64(p.Metrics.leased); got != float64(wantLeased) {
+				t.Errorf("leased jobs = %v, want %v", got, wantLeased)
+			}
+			if got := testutil.ToFloat64(p.Metrics.outcomes.WithLabelValues(testHandler, jobResultDeadLetter)); got != tt.wantDead {
+				t.Errorf("dead-lettered jobs = %v, want %v", got, tt.wantDead)
+			}
+		})
+	}
+}
+
+func TestPersistentQueueProcess(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		panics      bool
+		attempts    int
+		wantStatus  string
+		wantResult  string
+		wantError   string
+		wantDelayed bool
+	}{
+		{name: "success", attempts: 1, wantStatus: StatusDone, wantResult: jobResultSuccess},
+		{name: "retryable error", err: errors.New("unavailable"), attempts: 1, wantStatus: StatusPending, wantResult: jobResultRetry, wantError: "unavailable", wantDelayed: true},
+		{name: "panic is retried", panics: true, attempts: 1, wantStatus: StatusPending, wantResult: jobResultRetry, wantError: "job handler panicked: boom", wantDelayed: true},
+		{name: "error on last attempt", err: errors.New("unavailable"), attempts: 3, wantStatus: StatusDead, wantResult: jobResultDeadLetter, wantError: "unavailable"},
+		{name: "permanent error", err: module.Permanent(errors.New("invalid")), attempts: 1, wantStatus: StatusDead, wantResult: jobResultDeadLetter, wantError: "invalid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestQueue(t, func(context.Context, *Job) error {
+				if tt.panics {
+					panic("boom")
+				}
+				return tt.err
+			})
+
+			now := time.Now()
+			lockedUntil := now.Add(time.Minute)
+			job := &Job{
+				Queue:       p.GetName(),
+				Handler:     testHandler,
+				Status:      StatusRunning,
+				Attempts:    tt.attempts,
+				MaxAttempts: 3,
+				RunAt:       now,
+				LockedUntil: &lockedUntil,
+				LockedBy:    p.identity,
+			}
+			err := p.database.GetDB().Create(job).Error
+			if err != nil {
+				t.Fatalf("failed to create job: %s", err)
+			}
+
+			p.process(job)
+
+			stored := loadJob(t, p, job.ID)
+			if stored.Status != tt.wantStatus || stored.LastError != tt.wantError {
+				t.Errorf("job = %s (%q), want %s (%q)", stored.Status, stored.LastError, tt.wantStatus, tt.wantError)
+			}
+			if stored.LockedUntil != nil || stored.LockedBy != "" {
+				t.Errorf("job is still locked by %q until %v", stored.LockedBy, stored.LockedUntil)
+			}
+			if delayed := stored.RunAt.After(lockedUntil); delayed != tt.wantDelayed {
+				t.Errorf("job runs at %v, delayed = %v, want %v", stored.RunAt, delayed, tt.wantDelayed)
+			}
+			if got := testutil.ToFloat64(p.Metrics.outcomes.WithLabelValues(testHandler, tt.wantResult)); got != 1 {
+				t.Errorf("%s outcomes = %v, want 1", tt.wantResult, got)
+			}
+		})
+	}
+}
+
+// A worker whose lease expired must not overwrite the job after another
+// worker leased it again.
+func TestPersistentQueueProcessLostLease(t *testing.T) {
+	p := newTestQueue(t, func(context.Context, *Job) error { return nil })
+
+	lockedUntil := time.Now().Add(time.Minute)
+	job := &Job{
+		Queue:       p.GetName(),
+		Handler:     testHandler,
+		Status:      StatusRunning,
+		Attempts:    2,
+		MaxAttempts: 3,
+		RunAt:       time.Now(),
+		LockedUntil: &lockedUntil,
+		LockedBy:    "worker-2",
+	}
+	err := p.database.GetDB().Create(job).Error
+	if err != nil {
+		t.Fatalf("failed to create job: %s", err)
+	}
+
+	stale := *job
+	stale.Attempts = 1
+	stale.LockedBy = p.identity
+	p.process(&stale)
+
+	stored := loadJob(t, p, job.ID)
+	if stored.Status != StatusRunning || stored.LockedBy != "worker-2" {
+		t.Errorf("job = %s locked by %q, want %s locked by worker-2", stored.Status, stored.LockedBy, StatusRunning)
+	}
+}
+
+func TestPersistentQueueCleanupWaitsForLeasedJobs(t *testing.T) {
+	started := make(chan struct{})
+	p := newTestQueue(t, func(ctx context.Context, _ *Job) error {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		return ctx.Err()
+	})
+	p.config.PollInterval = time.Millisecond
+	p.config.DrainTimeout = 5 * time.Second
+
+	job, err := p.Enqueue(context.Background(), testHandler, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to enqueue job: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mainErr := make(chan error, 1)
+	go func() {
+		mainErr <- p.Main(ctx)
+	}()
+
+	<-started
+	cancel()
+	p.Cleanup(context.Background())
+
+	select {
+	case <-p.mainDone:
+	default:
+		t.Error("Cleanup returned before Main")
+	}
+	if err := <-mainErr; err != nil {
+		t.Errorf("Main() error = %s", err)
+	}
+
+	stored := loadJob(t, p, job.ID)
+	if stored.Status != StatusDone {
+		t.Errorf("job = %s (%q), want %s", stored.Status, stored.LastError, StatusDone)
+	}
+}
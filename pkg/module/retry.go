@@ -0,0 +1,147 @@
+package module
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime/debug"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+type RetryConfig struct {
+	// MaxAttempts includes the first attempt; values below 2 disable retries.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is the fraction of each backoff that is randomized, in [0, 1].
+	Jitter float64
+	// Retryable classifies errors. When nil, every error except context
+	// cancellation is retried.
+	Retryable func(err error) bool
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as non-retryable regardless of RetryConfig.Retryable.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsRetryable reports whether a failed attempt with err should be retried.
+func (c *RetryConfig) IsRetryable(err error) bool {
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if c.Retryable != nil {
+		return c.Retryable(err)
+	}
+	return true
+}
+
+// Backoff returns the delay before the attempt following attempt, counting
+// from 1.
+func (c *RetryConfig) Backoff(attempt int) time.Duration {
+	initial := c.InitialBackoff
+	if initial <= 0 {
+		initial = 100 * time.Millisecond
+	}
+
+	multiplier := c.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	backoff := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+
+	jitter := math.Min(math.Max(c.Jitter, 0), 1)
+	if jitter > 0 {
+		backoff = backoff * (1 - jitter + 2*jitter*rand.Float64())
+	}
+
+	if c.MaxBackoff > 0 && backoff > float64(c.MaxBackoff) {
+		backoff = float64(c.MaxBackoff)
+	}
+
+	return time.Duration(backoff)
+}
+
+// attempt runs the task once. Panics are reported as errors so that plain
+// tasks, which can't return one, are retried and counted as failures too.
+func (p *Task) attempt(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("Recovered from panic in periodic task", "name", p.GetName(), "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("periodic task panicked: %v", r)
+		}
+	}()
+
+	if p.config.TaskWithError == nil {
+		p.config.Task()
+		return nil
+	}
+	return p.config.TaskWithError(ctx)
+}
+
+func (p *Task) runWithRetry(ctx context.Context) error {
+	retry := p.config.Retry
+	maxAttempts := 1
+	if retry != nil && retry.MaxAttempts > 1 {
+		maxAttempts = retry.MaxAttempts
+	}
+
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = p.attempt(ctx)
+		if err == nil {
+			TaskMetrics.attempts.WithLabelValues(p.GetName(), taskResultSuccess).Inc()
+			TaskMetrics.runs.WithLabelValues(p.GetName(), taskResultSuccess).Inc()
+			if attempt > 1 {
+				log.Info("Periodic task succeeded after retry", "name", p.GetName(), "attempt", attempt)
+			}
+			return nil
+		}
+
+		TaskMetrics.attempts.WithLabelValues(p.GetName(), taskResultFailure).Inc()
+
+		if attempt == maxAttempts || !retry.IsRetryable(err) {
+			break
+		}
+
+		backoff := retry.Backoff(attempt)
+		log.Warn("Periodic task attempt failed, retrying", "name", p.GetName(), "attempt", attempt, "max_attempts", maxAttempts, "backoff", backoff, "error", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			TaskMetrics.runs.WithLabelValues(p.GetName(), taskResultFailure).Inc()
+			return err
+		case <-timer.C:
+		}
+	}
+
+	TaskMetrics.runs.WithLabelValues(p.GetName(), taskResultFailure).Inc()
+	return err
+}
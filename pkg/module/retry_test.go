@@ -0,0 +1,144 @@
+package module
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRetryConfigIsRetryable(t *testing.T) {
+	errTemporary := errors.New("temporary")
+	errFatal := errors.New("fatal")
+	onlyTemporary := func(err error) bool { return errors.Is(err, errTemporary) }
+
+	tests := []struct {
+		name      string
+		retryable func(err error) bool
+		err       error
+		want      bool
+	}{
+		{name: "any error by default", err: errFatal, want: true},
+		{name: "permanent", err: Permanent(errTemporary), want: false},
+		{name: "wrapped permanent", err: fmt.Errorf("job: %w", Permanent(errTemporary)), want: false},
+		{name: "context canceled", err: fmt.Errorf("query: %w", context.Canceled), want: false},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, want: true},
+		{name: "classified retryable", retryable: onlyTemporary, err: errTemporary, want: true},
+		{name: "classified permanent", retryable: onlyTemporary, err: errFatal, want: false},
+		{name: "permanent wins over classifier", retryable: onlyTemporary, err: Permanent(errTemporary), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &RetryConfig{Retryable: tt.retryable}
+			if got := config.IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryConfigBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  RetryConfig
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{name: "defaults", attempt: 1, min: 100 * time.Millisecond, max: 100 * time.Millisecond},
+		{name: "exponential", config: RetryConfig{InitialBackoff: time.Second, Multiplier: 3}, attempt: 3, min: 9 * time.Second, max: 9 * time.Second},
+		{name: "clamped", config: RetryConfig{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}, attempt: 10, min: 5 * time.Second, max: 5 * time.Second},
+		{name: "jitter", config: RetryConfig{InitialBackoff: time.Second, Jitter: 0.5}, attempt: 1, min: 500 * time.Millisecond, max: 1500 * time.Millisecond},
+		{name: "jitter clamped", config: RetryConfig{InitialBackoff: time.Second, MaxBackoff: time.Second, Jitter: 1}, attempt: 1, min: 0, max: time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				got := tt.config.Backoff(tt.attempt)
+				if got < tt.min || got > tt.max {
+					t.Fatalf("Backoff(%d) = %s, want between %s and %s", tt.attempt, got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestTaskRunWithRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       TaskConfig
+		wantErr      bool
+		wantAttempts map[string]float64
+		wantRuns     map[string]float64
+	}{
+		{
+			name:         "plain task",
+			config:       TaskConfig{Task: func() {}},
+			wantAttempts: map[string]float64{taskResultSuccess: 1},
+			wantRuns:     map[string]float64{taskResultSuccess: 1},
+		},
+		{
+			name:         "plain task panic is retried",
+			config:       TaskConfig{Task: panicOnce(), Retry: &RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond}},
+			wantAttempts: map[string]float64{taskResultSuccess: 1, taskResultFailure: 1},
+			wantRuns:     map[string]float64{taskResultSuccess: 1},
+		},
+		{
+			name: "retries until max attempts",
+			config: TaskConfig{
+				TaskWithError: func(context.Context) error { return errors.New("unavailable") },
+				Retry:         &RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			},
+			wantErr:      true,
+			wantAttempts: map[string]float64{taskResultFailure: 3},
+			wantRuns:     map[string]float64{taskResultFailure: 1},
+		},
+		{
+			name: "permanent errors are not retried",
+			config: TaskConfig{
+				TaskWithError: func(context.Context) error { return Permanent(errors.New("invalid")) },
+				Retry:         &RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			},
+			wantErr:      true,
+			wantAttempts: map[string]float64{taskResultFailure: 1},
+			wantRuns:     map[string]float64{taskResultFailure: 1},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.Name = fmt.Sprintf("retry-test-%d", i)
+			task := NewTaskFromConfig(&config)
+
+			err := task.runWithRetry(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("runWithRetry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			for _, result := range []string{taskResultSuccess, taskResultFailure} {
+				if got := testutil.ToFloat64(TaskMetrics.attempts.WithLabelValues(config.Name, result)); got != tt.wantAttempts[result] {
+					t.Errorf("%s attempts = %v, want %v", result, got, tt.wantAttempts[result])
+				}
+				if got := testutil.ToFloat64(TaskMetrics.runs.WithLabelValues(config.Name, result)); got != tt.wantRuns[result] {
+					t.Errorf("%s runs = %v, want %v", result, got, tt.wantRuns[result])
+				}
+			}
+		})
+	}
+}
+
+func panicOnce() TaskFunc {
+	panicked := false
+	return func() {
+		if !panicked {
+			panicked = true
+			panic("boom")
+		}
+	}
+}